@@ -0,0 +1,25 @@
+/*
+A user's activity is everything they have written, newest first, as shown on
+their profile. Each item carries enough of its surroundings (the thread it is in
+and what it replied to) to be understood out of context.
+
+For activity methods and functions that access a database, see activity_db.go
+*/
+package forum
+
+const (
+	SNIPPET_LENGTH    = 140 //Number of characters of a parent entry's body shown alongside a reply
+	ACTIVITY_PER_PAGE = 25  //Number of items on one page of a user's activity
+)
+
+type Activity struct {
+	Entry         *Entry //The post or comment itself, with the viewer's vote in UserVote
+	ThreadId      int64  //The ID of the post at the root of the entry's thread; the entry's own ID if it is a post
+	ThreadTitle   string //Title of the post at the root of the entry's thread
+	ParentSnippet string //The start of the body of the entry's parent, or the parent's title if it is a forum
+}
+
+//Whether the item is a post rather than a comment
+func (a *Activity) IsPost() bool {
+	return a.Entry.Id == a.ThreadId
+}
@@ -0,0 +1,35 @@
+/*
+Activity methods and functions that access a database are placed here.
+*/
+package forum
+
+// Retrieves one page (starting from 0) of the posts and comments written by a
+// user, newest first, as seen by viewer. Deleted entries are left out, as are
+// the entries of shadow-banned authors unless the viewer is the author.
+func EntriesByAuthor(authorId int64, viewer User, page int) ([]*Activity, error) {
+	if page < 0 {
+		page = 0
+	}
+
+	rows, err := Config.DB.Query(queries.EntriesByAuthor, authorId, viewer.GetId(), ACTIVITY_PER_PAGE, page*ACTIVITY_PER_PAGE, SNIPPET_LENGTH)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	activity := make([]*Activity, 0, ACTIVITY_PER_PAGE)
+	for rows.Next() {
+		a := &Activity{Entry: New()}
+		e := a.Entry
+		err = rows.Scan(&e.Id, &e.Title, &e.Body, &e.Url, &e.Created, &e.AuthorId, &e.Forum, &e.Deleted, &e.Locked, &e.Pinned, &e.AuthorHandle, &e.Seconds, &e.Upvotes, &e.Downvotes, &e.UserVote.Upvote, &e.UserVote.Downvote,
+			&a.ThreadId, &a.ThreadTitle, &e.ParentId, &a.ParentSnippet)
+		if err != nil {
+			return nil, err
+		}
+		e.UserVote.EntryId, e.UserVote.UserId = e.Id, viewer.GetId()
+
+		activity = append(activity, a)
+	}
+
+	return activity, rows.Err()
+}
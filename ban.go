@@ -0,0 +1,44 @@
+/*
+Moderators can ban a user from a forum and all of its sub-forums, either until a
+given time or permanently. A banned user can neither post nor vote there. A
+shadow ban instead lets the user carry on: their entries are stored but are only
+shown to themselves, and their votes are quietly discarded.
+
+For ban methods and functions that access a database, see ban_db.go
+*/
+package forum
+
+import (
+	"fmt"
+	"time"
+)
+
+type ForumBan struct {
+	ForumId    int64     //The ID of the forum the user is banned from
+	UserId     int64     //The ID of the banned user
+	UserHandle string    //Name of the banned user
+	BannedBy   int64     //The ID of the moderator who issued the ban
+	Created    time.Time //Time at which the ban was issued
+	Expires    time.Time //Time at which the ban is lifted; zero for a permanent ban
+	Shadow     bool      //Is this a shadow ban?
+}
+
+//Whether the ban lasts forever
+func (b *ForumBan) Permanent() bool {
+	return b.Expires.IsZero()
+}
+
+//BannedError is returned when a banned user tries to post or vote
+type BannedError struct {
+	ForumId int64     //The forum the user is banned from
+	UserId  int64     //The banned user
+	Expires time.Time //Time at which the ban is lifted; zero for a permanent ban
+}
+
+func (err *BannedError) Error() string {
+	if err.Expires.IsZero() {
+		return fmt.Sprintf("Error: You have been banned from forum %d.", err.ForumId)
+	}
+
+	return fmt.Sprintf("Error: You have been banned from forum %d until %s.", err.ForumId, err.Expires.Format(time.RFC1123))
+}
@@ -0,0 +1,167 @@
+/*
+Ban methods and functions that access a database are placed here.
+*/
+package forum
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Bans the user with ID userId from a forum and all of its sub-forums. A
+// duration of 0 bans them permanently. If shadow is set, the user is not told:
+// they may keep posting, but nobody else sees what they post.
+func Ban(forumId, userId int64, by User, duration time.Duration, shadow bool) error {
+	var expires time.Time
+	if duration > 0 {
+		expires = time.Now().Add(duration)
+	}
+
+	//Wrap in a transaction
+	tx, err := Config.DB.Begin()
+	if err != nil {
+		return errors.New("Error: We had a database problem trying to ban the user.")
+	}
+
+	if err = banUser(tx, forumId, userId, by, expires, shadow); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.New("Error: The user could not be banned.")
+	}
+
+	return nil
+}
+
+func banUser(tx *sql.Tx, forumId, userId int64, by User, expires time.Time, shadow bool) error {
+	forum, err := entryState(tx, queries.EntryState, forumId)
+	if err != nil {
+		return err
+	}
+	if !forum.Forum {
+		return errors.New("Error: Users can only be banned from forums.")
+	}
+
+	if err = authorize(tx, by, ACTION_BAN, forum); err != nil {
+		return err
+	}
+
+	after := "permanent"
+	if !expires.IsZero() {
+		after = "until " + expires.Format(time.RFC3339)
+	}
+	if shadow {
+		after = "shadow, " + after
+	}
+
+	if err = logAction(tx, by, forumId, LOG_BAN, fmt.Sprintf("user %d", userId), after); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(queries.BanCreate, forumId, userId, by.GetId(), sql.NullTime{Time: expires, Valid: !expires.IsZero()}, shadow); err != nil {
+		return errors.New("Error: We had a database problem trying to ban the user.")
+	}
+
+	return nil
+}
+
+// Lifts every ban of the user with ID userId from a forum. Bans from the
+// forum's ancestors still apply.
+func Unban(forumId, userId int64, by User) error {
+	//Wrap in a transaction
+	tx, err := Config.DB.Begin()
+	if err != nil {
+		return errors.New("Error: We had a database problem trying to lift the ban.")
+	}
+
+	forum, err := entryState(tx, queries.EntryState, forumId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = authorize(tx, by, ACTION_BAN, forum); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = logAction(tx, by, forumId, LOG_UNBAN, fmt.Sprintf("user %d", userId), ""); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec(queries.BansLift, forumId, userId); err != nil {
+		tx.Rollback()
+		return errors.New("Error: We had a database problem trying to lift the ban.")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.New("Error: The ban could not be lifted.")
+	}
+
+	return nil
+}
+
+// Lists the bans from a forum that have not yet expired, newest first. Bans
+// from the forum's ancestors are not included.
+func ForumBans(forumId int64) ([]*ForumBan, error) {
+	rows, err := Config.DB.Query(queries.ForumBans, forumId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bans := make([]*ForumBan, 0)
+	for rows.Next() {
+		b := new(ForumBan)
+		var expires sql.NullTime
+		if err = rows.Scan(&b.ForumId, &b.UserId, &b.UserHandle, &b.BannedBy, &b.Created, &expires, &b.Shadow); err != nil {
+			return nil, err
+		}
+		if expires.Valid {
+			b.Expires = expires.Time
+		}
+
+		bans = append(bans, b)
+	}
+
+	return bans, rows.Err()
+}
+
+// Returns the ban, if any, that keeps the user with ID userId from posting or
+// voting beneath the entry with ID entryId. Real bans take precedence over
+// shadow bans. A nil ban means the user is not banned.
+func activeBan(q queryRower, entryId, userId int64) (*ForumBan, error) {
+	if entryId == 0 {
+		return nil, nil
+	}
+
+	b := &ForumBan{UserId: userId}
+	var expires sql.NullTime
+
+	err := q.QueryRow(queries.ActiveBan, entryId, userId).Scan(&b.ForumId, &expires, &b.Shadow)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.New("Error: We had a database problem trying to check for bans.")
+	}
+	if expires.Valid {
+		b.Expires = expires.Time
+	}
+
+	return b, nil
+}
+
+//Reports whether the author of the entry is shadow-banned from a forum above it
+func entryShadowed(q queryRower, entryId int64) (bool, error) {
+	var shadowed bool
+	if err := q.QueryRow(queries.EntryShadowed, entryId).Scan(&shadowed); err != nil {
+		return false, err
+	}
+
+	return shadowed, nil
+}
@@ -1,14 +1,22 @@
 package forum
 
 import (
+	"fmt"
 	"time"
 )
 
+//A Delta records one edit of an entry by keeping what the edit replaced
 type Delta struct {
-	Id         int64     "Unique identifier of this delta"
-	PostId     int64     "ID of the modified post"
-	TitleDelta string    "Changes made to the title, if any"
-	BodyDelta  string    "Changes made to the body, if any"
-	Modified   time.Time "Time at which the changes were made"
-	ModifierId int64     "ID of the user who modified the post"
+	Id         int64     //Unique identifier of this delta
+	PostId     int64     //ID of the modified post
+	TitleDelta string    //The title before the change, if the title changed
+	BodyDelta  string    //The body before the change, if the body changed
+	Modified   time.Time //Time at which the changes were made
+	ModifierId int64     //ID of the user who modified the post
+}
+
+//How the moderation log refers to this delta, so that it never has to quote
+//the text itself
+func (d *Delta) reference() string {
+	return fmt.Sprintf("delta %d", d.Id)
 }
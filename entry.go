@@ -14,33 +14,44 @@ import (
 )
 
 const (
-	DECAY = 0.5 //Decay factor for childrens' scores
+	DECAY   = 0.5         //Decay factor for childrens' scores
+	DELETED = "[deleted]" //Shown in place of the body and author of a deleted entry
 )
 
 // Put ModifiedBy, ModifiedAuthor in a separate table. A post can only be
 // created once but modified an infinite number of times.
 type Entry struct {
-	Id       int64     //The ID of the post
-	Title    string    //Title of the post. Will be empty for entries that are really intended to be comments.
-	Body     string    //Contents of the post. Will be empty for entries that are intended to be links.
-	Created  time.Time //Time at which the post was created.
-	AuthorId int64     `schema:"-"` //ID of the author of the post
-	Forum    bool      `schema:"-"` //Is this Entry actually a forum instead?
-	Url      bool      `schema:"-"` //Is this Entry just a link?
+	Id         int64     //The ID of the post
+	Title      string    //Title of the post. Will be empty for entries that are really intended to be comments.
+	Body       string    //Contents of the post. Will be empty for entries that are intended to be links.
+	Created    time.Time //Time at which the post was created.
+	AuthorId   int64     `schema:"-"` //ID of the author of the post
+	Forum      bool      `schema:"-"` //Is this Entry actually a forum instead?
+	Url        bool      `schema:"-"` //Is this Entry just a link?
+	Deleted    bool      `schema:"-"` //Has this Entry been deleted? Its author is hidden and its Body replaced.
+	Locked     bool      `schema:"-"` //Is this Entry locked? No new replies may be made anywhere beneath it.
+	Pinned     bool      `schema:"-"` //Is this Entry pinned? It is listed first in its forum regardless of Score.
+	RedirectId int64     `schema:"-"` //If this Entry was merged into another thread, the ID of that thread
 
 	//Fields beneath this line are not persisted to the Entry table
 
-	AuthorHandle string  //Name of the author
-	Seconds      float64 //Seconds since creation
-	Upvotes      int64
-	Downvotes    int64
-	ParentId     int64 //ID of the parent of this post, if any
+	AuthorHandle string    //Name of the author
+	Seconds      float64   //Seconds since creation
+	Upvotes      int64     //Counter kept on the entry row by Vote.Persist; never written by Entry.Persist
+	Downvotes    int64     //Counter kept on the entry row by Vote.Persist; never written by Entry.Persist
+	ParentId     int64     //ID of the parent of this post, if any
+	New          bool      //Was this entry created since the current user last visited its thread? Never set for their own entries.
+	NewCount     int64     //Number of descendants created since the current user last visited, once attached by AttachNewCounts
+	Modified     time.Time //Time of the latest edit, where loaded (e.g. for feeds); zero if never edited or not loaded
 
 	//Memoization
 	childCount    int64 //For caching the count of child entries by ChildCount()
 	hasChildCount bool  //For indicating whether there is a cached value (since childCount is ambiguous: 0 for init and 0 if there are 0 children)
 
-	UserVote *Vote //A Vote representing how the current user has voted on this Entry
+	UserVote *Vote        //A Vote representing how the current user has voted on this Entry
+	Preview  *LinkPreview //Metadata about the linked document, if this Entry is a link that has been previewed
+	Mentions []*Mention   //The users mentioned in Body, once stored or attached by AttachMentions
+	Author   User         `schema:"-"` //Optional: the User creating this Entry. Persist consults it for permissions.
 
 	parent, child, sibling *Entry //Mandatory pointer-holders for Tree-ness
 }
@@ -58,6 +69,18 @@ func (e *Entry) Root() *Entry {
 	return e.Parent()
 }
 
+//Walk calls fn for the entry and then for each of its descendants, depth first
+func (e *Entry) Walk(fn func(*Entry)) {
+	if e == nil {
+		return
+	}
+
+	fn(e)
+	for c := e.Child(); c != nil; c = c.Sibling() {
+		c.Walk(fn)
+	}
+}
+
 func (e *Entry) Child() *Entry        { return e.child }
 func (e *Entry) Sibling() *Entry      { return e.sibling }
 func (e *Entry) Parent() *Entry       { return e.parent }
@@ -193,6 +216,8 @@ func (e *Entry) recursivePoints() float64 {
 	return float64(e.Points()) + DECAY*(e.Child().recursivePoints()+e.Sibling().recursivePoints())
 }
 
+//ChildCount returns the number of descendants of an entry. It is counted from the
+//loaded tree, unless the entry was loaded by a listing that counted them for us.
 func (e *Entry) ChildCount() int64 {
 	if !e.hasChildCount {
 		//Memoize childCount
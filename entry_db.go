@@ -6,12 +6,18 @@ package forum
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"strconv"
 	"strings"
 )
 
 // Stores an entry to the database and correctly builds its ancestry based
 // on its parent's ID.
 func (e *Entry) Persist(parentId int64) error {
+	if e.Author != nil {
+		e.AuthorId = e.Author.GetId()
+	}
+
 	//Trim
 	e.Title = strings.TrimSpace(e.Title)
 	e.Body = strings.TrimSpace(e.Body)
@@ -23,6 +29,43 @@ func (e *Entry) Persist(parentId int64) error {
 
 	//Wrap in a transaction
 	tx, err := Config.DB.Begin()
+	if err != nil {
+		return errors.New("Error: We had a database problem trying to create your entry.")
+	}
+
+	var parent *Entry
+	if parentId != 0 {
+		if parent, err = entryState(tx, queries.EntryState, parentId); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	action := ACTION_POST
+	if e.Forum {
+		action = ACTION_CREATE_FORUM
+	}
+	if err = authorize(tx, e.author(), action, parent); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	//Shadow-banned authors may post; only they will see it
+	ban, err := activeBan(tx, parentId, e.AuthorId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if ban != nil && !ban.Shadow {
+		tx.Rollback()
+		return &BannedError{ForumId: ban.ForumId, UserId: e.AuthorId, Expires: ban.Expires}
+	}
+
+	//Replies may not be made to locked or archived threads
+	if err = checkThreadState(tx, parentId, true); err != nil {
+		tx.Rollback()
+		return err
+	}
 
 	EntryCreateStmt, err := tx.Prepare(queries.EntryCreate)
 	if err != nil {
@@ -34,7 +77,7 @@ func (e *Entry) Persist(parentId int64) error {
 	//Note: because pq handles LastInsertId oddly (or not at all?), instead of
 	//calling .Exec() then .LastInsertId, we prepare a statement that ends in
 	//`RETURNING id` and we .QueryRow().Select() the result
-	err = EntryCreateStmt.QueryRow(e.Title, e.Body, e.Url, e.AuthorId).Scan(&e.Id)
+	err = EntryCreateStmt.QueryRow(e.Title, e.Body, e.Url, e.AuthorId, e.Forum).Scan(&e.Id)
 	if err != nil {
 		tx.Rollback()
 		return errors.New("Error: there was an error when trying to persist the entry to the database; it was not saved.")
@@ -54,13 +97,52 @@ func (e *Entry) Persist(parentId int64) error {
 		return errors.New("Error: We couldn't save the relationship between your comment and its parent comment.")
 	}
 
-	tx.Commit()
+	if err = e.storeMentions(tx); err != nil {
+		tx.Rollback()
+		return errors.New("Error: We couldn't save the users mentioned in your entry.")
+	}
+
+	if err = e.notify(tx, parent, ban != nil); err != nil {
+		tx.Rollback()
+		return errors.New("Error: We couldn't notify anyone of your entry, so it was not saved.")
+	}
+
+	//Entries by shadow-banned authors are kept from everyone else, downstream services included
+	var ev *Event
+	if ban == nil {
+		if ev, err = emit(tx, EVENT_ENTRY_CREATED, e.Id, e.AuthorId, 0); err != nil {
+			tx.Rollback()
+			return errors.New("Error: We couldn't record an event for your entry, so it was not saved.")
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.New("Error: Your entry could not be saved.")
+	}
+
+	dispatch(ev)
 
 	return nil
 }
 
-//Retrieve one entry by its ID, if it exists. Error if not.
+//The User who is creating the entry, as far as we know it
+func (e *Entry) author() User {
+	if e.Author != nil {
+		return e.Author
+	}
+
+	return userId(e.AuthorId)
+}
+
+//Retrieve one entry by its ID, if it exists. Error if not. Entries by
+//shadow-banned authors are treated as not existing.
 func OneEntry(id int64) (*Entry, error) {
+	return OneEntryAs(id, userId(0))
+}
+
+//Retrieve one entry by its ID as seen by viewer, if it exists. Error if not.
+//Entries by shadow-banned authors exist only for their authors.
+func OneEntryAs(id int64, viewer User) (*Entry, error) {
 	e := new(Entry)
 	var err error = nil
 
@@ -70,7 +152,7 @@ func OneEntry(id int64) (*Entry, error) {
 	}
 	defer stmt.Close()
 
-	err = stmt.QueryRow(id).Scan(&e.Id, &e.Title, &e.Body, &e.Url, &e.Created, &e.AuthorId, &e.Forum, &e.AuthorHandle, &e.Seconds, &e.Upvotes, &e.Downvotes)
+	err = stmt.QueryRow(id, viewer.GetId()).Scan(&e.Id, &e.Title, &e.Body, &e.Url, &e.Created, &e.AuthorId, &e.Forum, &e.Deleted, &e.Locked, &e.Pinned, &e.AuthorHandle, &e.Seconds, &e.Upvotes, &e.Downvotes, &e.RedirectId)
 	if err != nil {
 		e = new(Entry)
 		return e, err
@@ -113,7 +195,12 @@ func getEntries(root int64, flag string, user User) (*Entry, error) {
 		}
 	case "AllAncestors":
 		stmt, err = Config.DB.Prepare(queries.AncestorEntriesChildParent)
-		getRoot = func(entries map[int64]*Entry, root int64) int64 { return entries[root].Root().Id }
+		getRoot = func(entries map[int64]*Entry, root int64) int64 {
+			if entries[root] == nil {
+				return root
+			}
+			return entries[root].Root().Id
+		}
 		buildRelationship = func(ancestorId, entryId int64) map[string]int64 {
 			return map[string]int64{"Parent": ancestorId, "Child": entryId}
 		}
@@ -140,7 +227,7 @@ func getEntries(root int64, flag string, user User) (*Entry, error) {
 	for rows.Next() {
 		var e *Entry = New()
 		var ancestor int64
-		err = rows.Scan(&ancestor, &e.Id, &e.Title, &e.Body, &e.Url, &e.Created, &e.AuthorId, &e.Forum, &e.AuthorHandle, &e.Seconds, &e.Upvotes, &e.Downvotes, &e.UserVote.Upvote, &e.UserVote.Downvote)
+		err = rows.Scan(&ancestor, &e.Id, &e.Title, &e.Body, &e.Url, &e.Created, &e.AuthorId, &e.Forum, &e.Deleted, &e.Locked, &e.Pinned, &e.AuthorHandle, &e.Seconds, &e.Upvotes, &e.Downvotes, &e.UserVote.Upvote, &e.UserVote.Downvote, &e.New)
 		if err != nil {
 			return e, err
 		}
@@ -154,8 +241,435 @@ func getEntries(root int64, flag string, user User) (*Entry, error) {
 		if rel["Parent"] == rel["Child"] {
 			continue
 		}
+		if entries[rel["Parent"]] == nil || entries[rel["Child"]] == nil {
+			//One side was hidden from this user (e.g., by a shadow ban), so the relationship is too
+			continue
+		}
 		entries[int64(rel["Parent"])].AddChild(entries[int64(rel["Child"])])
 	}
 
 	return Arrange(entries[getRoot(entries, root)]), nil
 }
+
+// Soft-deletes an entry. Its Body is replaced and its author is hidden, but the
+// entry keeps its place (and its closure table rows) so that replies to it
+// remain attached to the thread. The deleted body is kept as a Delta, and
+// deletions by anyone other than the author are written to the moderation log.
+func (e *Entry) Delete(by User, reason string) error {
+	reason = strings.TrimSpace(reason)
+
+	//Wrap in a transaction
+	tx, err := Config.DB.Begin()
+	if err != nil {
+		return errors.New("Error: We had a database problem trying to delete the entry.")
+	}
+
+	old, err := entryForUpdate(tx, e.Id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = authorize(tx, by, ACTION_DELETE, old); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	ev, err := softDelete(tx, old, by, reason)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.New("Error: The entry could not be deleted.")
+	}
+
+	e.Body, e.Deleted, e.AuthorId, e.AuthorHandle = DELETED, true, 0, DELETED
+
+	dispatch(ev)
+
+	return nil
+}
+
+//Soft-deletes old, which the caller has locked with entryForUpdate, and returns
+//the event to dispatch once the transaction commits
+func softDelete(tx *sql.Tx, old *Entry, by User, reason string) (*Event, error) {
+	//The deleted body is kept in the entry's edit history, which HardDelete purges, rather than in the log
+	d := &Delta{PostId: old.Id, BodyDelta: old.Body, ModifierId: by.GetId()}
+	if err := tx.QueryRow(queries.DeltaCreate, d.PostId, d.TitleDelta, d.BodyDelta, d.ModifierId).Scan(&d.Id, &d.Modified); err != nil {
+		return nil, errors.New("Error: The deleted text could not be kept in the entry's history, so it was not deleted.")
+	}
+
+	if old.AuthorId != by.GetId() {
+		if err := logAction(tx, by, old.Id, LOG_DELETE, d.reference(), reason); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.Exec(queries.EntrySoftDelete, old.Id, DELETED, by.GetId(), reason); err != nil {
+		return nil, errors.New("Error: The entry could not be deleted.")
+	}
+
+	ev, err := emitUnlessShadowed(tx, EVENT_ENTRY_DELETED, old.Id, by.GetId(), 0)
+	if err != nil {
+		return nil, errors.New("Error: We couldn't record an event for the deletion, so the entry was not deleted.")
+	}
+
+	return ev, nil
+}
+
+// Replaces the title and body of an entry. The previous version is kept as a
+// Delta, and edits by anyone other than the author are written to the
+// moderation log. Mentions are parsed again from the new body, and users it
+// newly mentions are notified.
+func (e *Entry) Edit(by User, title, body string) (*Delta, error) {
+	title, body = strings.TrimSpace(title), strings.TrimSpace(body)
+
+	//Validate
+	if body == "" {
+		return nil, errors.New("The Body must not be empty or consist solely of whitespace.")
+	}
+
+	//Wrap in a transaction
+	tx, err := Config.DB.Begin()
+	if err != nil {
+		return nil, errors.New("Error: We had a database problem trying to edit the entry.")
+	}
+
+	d, mentions, err := editEntry(tx, e.Id, by, title, body)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	ev, err := emitUnlessShadowed(tx, EVENT_ENTRY_EDITED, e.Id, by.GetId(), 0)
+	if err != nil {
+		tx.Rollback()
+		return nil, errors.New("Error: We couldn't record an event for the edit, so the entry was not edited.")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, errors.New("Error: The entry could not be edited.")
+	}
+
+	e.Title, e.Body, e.Mentions = title, body, mentions
+
+	dispatch(ev)
+
+	return d, nil
+}
+
+func editEntry(tx *sql.Tx, id int64, by User, title, body string) (*Delta, []*Mention, error) {
+	old, err := entryForUpdate(tx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err = authorize(tx, by, ACTION_EDIT, old); err != nil {
+		return nil, nil, err
+	}
+
+	if old.Deleted {
+		return nil, nil, errors.New("Error: Deleted entries cannot be edited.")
+	}
+
+	d := &Delta{PostId: id, ModifierId: by.GetId()}
+	if title != old.Title {
+		d.TitleDelta = old.Title
+	}
+	if body != old.Body {
+		d.BodyDelta = old.Body
+	}
+
+	if _, err = tx.Exec(queries.EntryEdit, id, title, body); err != nil {
+		return nil, nil, errors.New("Error: The entry could not be edited.")
+	}
+
+	//The mentions follow the body
+	cur := New()
+	cur.Id, cur.AuthorId, cur.Body = id, old.AuthorId, body
+	if err = cur.replaceMentions(tx, by); err != nil {
+		return nil, nil, errors.New("Error: The mentions in the entry could not be updated, so it was not edited.")
+	}
+
+	if err = tx.QueryRow(queries.DeltaCreate, d.PostId, d.TitleDelta, d.BodyDelta, d.ModifierId).Scan(&d.Id, &d.Modified); err != nil {
+		return nil, nil, errors.New("Error: The previous version of the entry could not be saved, so it was not edited.")
+	}
+
+	//The log points at the previous version instead of quoting it; the new one is the entry itself
+	if old.AuthorId != by.GetId() {
+		if err = logAction(tx, by, id, LOG_EDIT, d.reference(), "edited"); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return d, cur.Mentions, nil
+}
+
+//Locks the row of an entry for the rest of the transaction and returns its
+//current, unhidden author, title, body and state.
+func entryForUpdate(tx *sql.Tx, id int64) (*Entry, error) {
+	return entryState(tx, queries.EntryForUpdate, id)
+}
+
+func entryState(q queryRower, query string, id int64) (*Entry, error) {
+	e := New()
+	e.Id = id
+
+	err := q.QueryRow(query, id).Scan(&e.AuthorId, &e.Title, &e.Body, &e.Forum, &e.Deleted, &e.Locked, &e.Pinned)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("Error: The entry could not be found.")
+	} else if err != nil {
+		return nil, errors.New("Error: We had a database problem trying to find the entry.")
+	}
+
+	return e, nil
+}
+
+// Permanently removes an entry together with every one of its descendants,
+// their votes, link previews, edit history, reports and closure table rows, and
+// the bans, moderators and karma of any forums among them, e.g. for legal
+// takedowns. Whatever the moderation log quoted from them is redacted. Either
+// all of it is removed or none of it is. The removal itself is recorded.
+func (e *Entry) HardDelete(by User, reason string) error {
+	//Wrap in a transaction
+	tx, err := Config.DB.Begin()
+	if err != nil {
+		return errors.New("Error: We had a database problem trying to delete the entry.")
+	}
+
+	old, err := entryForUpdate(tx, e.Id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = authorize(tx, by, ACTION_HARD_DELETE, old); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	ids, err := subtreeIds(tx, e.Id)
+	if err != nil {
+		tx.Rollback()
+		return errors.New("Error: We had a database problem trying to find the replies to the entry.")
+	}
+	if len(ids) == 0 {
+		tx.Rollback()
+		return errors.New("Error: The entry to be deleted could not be found.")
+	}
+
+	if _, err = tx.Exec(queries.TakedownCreate, e.Id, len(ids), by.GetId(), strings.TrimSpace(reason)); err != nil {
+		tx.Rollback()
+		return errors.New("Error: The deletion could not be recorded; nothing was removed.")
+	}
+
+	//The karma the entries earned goes with their votes
+	if err = shiftKarma(tx, e.Id, -1); err != nil {
+		tx.Rollback()
+		return errors.New("Error: The authors' karma could not be updated; nothing was removed.")
+	}
+
+	//Remove everything else that was written about the entries, so that none of their text survives
+	for _, query := range []string{
+		queries.SubtreeVotesDelete,
+		queries.SubtreeVoteEventsDelete,
+		queries.SubtreePreviewsDelete,
+		queries.SubtreeDeltasDelete,
+		queries.SubtreeReportsDelete,
+		queries.SubtreeMergesDelete,
+		queries.SubtreeNotificationsDelete,
+		queries.SubtreeMentionsDelete,
+		queries.SubtreeSubscriptionsDelete,
+		queries.SubtreeVisitsDelete,
+		queries.SubtreeOutboxDelete,
+		queries.SubtreeBansDelete,
+		queries.SubtreeModeratorsDelete,
+		queries.SubtreeKarmaDelete,
+	} {
+		if _, err = tx.Exec(query, int64Array(ids)); err != nil {
+			tx.Rollback()
+			return errors.New("Error: The entry could not be deleted; nothing was removed.")
+		}
+	}
+
+	//The moderation log keeps its rows, but not the text they quoted
+	if _, err = tx.Exec(queries.SubtreeModLogRedact, int64Array(ids), DELETED); err != nil {
+		tx.Rollback()
+		return errors.New("Error: The entry could not be deleted; nothing was removed.")
+	}
+
+	//Log while the entry can still be traced to its forum
+	if err = logAction(tx, by, e.Id, LOG_HARD_DELETE, fmt.Sprintf("%d entries", len(ids)), strings.TrimSpace(reason)); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	//Likewise, the event needs the entry's ancestors for routing
+	ev, err := emit(tx, EVENT_ENTRY_REMOVED, e.Id, by.GetId(), 0)
+	if err != nil {
+		tx.Rollback()
+		return errors.New("Error: We couldn't record an event for the deletion; nothing was removed.")
+	}
+
+	//The IDs were collected up front, so the closure rows can be dropped before the entries they point to
+	for _, query := range []string{queries.SubtreeClosuresDelete, queries.SubtreeEntriesDelete} {
+		if _, err = tx.Exec(query, int64Array(ids)); err != nil {
+			tx.Rollback()
+			return errors.New("Error: The entry could not be deleted; nothing was removed.")
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.New("Error: The entry could not be deleted; nothing was removed.")
+	}
+
+	dispatch(ev)
+
+	return nil
+}
+
+//Returns the IDs of an entry and all of its descendants
+func subtreeIds(tx *sql.Tx, id int64) ([]int64, error) {
+	rows, err := tx.Query(queries.SubtreeIds, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var descendant int64
+		if err = rows.Scan(&descendant); err != nil {
+			return nil, err
+		}
+		ids = append(ids, descendant)
+	}
+
+	return ids, rows.Err()
+}
+
+//Formats IDs as a Postgres array literal, to be cast with $n::bigint[]
+func int64Array(ids []int64) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatInt(id, 10)
+	}
+
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+//Formats strings as a Postgres array literal, to be cast with $n::text[]
+func textArray(ss []string) string {
+	parts := make([]string, len(ss))
+	for i, s := range ss {
+		s = strings.Replace(s, `\`, `\\`, -1)
+		parts[i] = `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+	}
+
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// Moves an entry, along with all of its descendants, so that it becomes a child
+// of newParentId. If newParentId is 0 the entry becomes a root. An entry cannot
+// be moved underneath itself or one of its own descendants, and a forum can
+// only be moved into another forum or to the top level. The move is written
+// to the moderation log of the forum the entry was moved from.
+func MoveEntry(id, newParentId int64, by User) error {
+	//Wrap in a transaction
+	tx, err := Config.DB.Begin()
+	if err != nil {
+		return errors.New("Error: We had a database problem trying to move the entry.")
+	}
+
+	//The mover must moderate both the entry and its destination. A new parent
+	//of 0 is checked like any other top-level action.
+	for _, target := range []int64{id, newParentId} {
+		var e *Entry
+		if target != 0 {
+			if e, err = entryState(tx, queries.EntryState, target); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+
+		if err = authorize(tx, by, ACTION_MOVE, e); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	var oldParentId int64
+	if err = tx.QueryRow(queries.ParentOf, id).Scan(&oldParentId); err != nil && err != sql.ErrNoRows {
+		tx.Rollback()
+		return errors.New("Error: We had a database problem trying to move the entry.")
+	}
+
+	err = logAction(tx, by, id, LOG_MOVE, fmt.Sprintf("parent %d", oldParentId), fmt.Sprintf("parent %d", newParentId))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = moveSubtree(tx, id, newParentId); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.New("Error: The entry could not be moved.")
+	}
+
+	return nil
+}
+
+//Rewrites the closure table rows of the subtree rooted at id so that it hangs
+//from newParentId, with depths corrected for its new position.
+func moveSubtree(tx *sql.Tx, id, newParentId int64) error {
+	moved, err := entryState(tx, queries.EntryState, id)
+	if err != nil {
+		return errors.New("Error: The entry to be moved could not be found.")
+	}
+
+	if newParentId != 0 {
+		parent, err := entryState(tx, queries.EntryState, newParentId)
+		if err != nil {
+			return errors.New("Error: The new parent could not be found.")
+		}
+
+		//Posts must stay directly beneath a forum, so forums only nest in forums
+		if moved.Forum && !parent.Forum {
+			return errors.New("Error: A forum can only be moved into another forum.")
+		}
+
+		var cycle bool
+		if err := tx.QueryRow(queries.IsDescendant, id, newParentId).Scan(&cycle); err != nil {
+			return errors.New("Error: We had a database problem trying to move the entry.")
+		}
+		if cycle {
+			return errors.New("Error: An entry cannot be moved underneath itself or one of its replies.")
+		}
+	}
+
+	//Karma follows the entries: it is taken from the forums they leave and given to the ones they join
+	if err := shiftKarma(tx, id, -1); err != nil {
+		return errors.New("Error: We couldn't move the karma earned by the entry.")
+	}
+
+	if _, err := tx.Exec(queries.SubtreeDisconnect, id); err != nil {
+		return errors.New("Error: We couldn't detach the entry from its old parent.")
+	}
+
+	if newParentId != 0 {
+		if _, err := tx.Exec(queries.SubtreeConnect, id, newParentId); err != nil {
+			return errors.New("Error: We couldn't attach the entry to its new parent.")
+		}
+	}
+
+	if err := shiftKarma(tx, id, 1); err != nil {
+		return errors.New("Error: We couldn't move the karma earned by the entry.")
+	}
+
+	return nil
+}
@@ -84,3 +84,46 @@ func walk(e *Entry) []byte {
 
 	return output
 }
+
+func TestWalk(t *testing.T) {
+	x := &Entry{Title: "Root"}
+	a := &Entry{Title: "A"}
+	a.AddChild(&Entry{Title: "A1"})
+	x.AddChild(&Entry{Title: "B"})
+	x.AddChild(a)
+
+	output := ""
+	x.Walk(func(e *Entry) { output += e.Title + ":" })
+
+	if expected := string(walk(x)); output != expected {
+		t.Errorf("Got %s, expected %s", output, expected)
+	}
+
+	//Walking a subtree must not wander into the subtree's siblings
+	output = ""
+	a.Walk(func(e *Entry) { output += e.Title + ":" })
+
+	if expected := "A:A1:"; output != expected {
+		t.Errorf("Got %s, expected %s", output, expected)
+	}
+}
+
+func TestInt64Array(t *testing.T) {
+	if s := int64Array([]int64{3, 1, 2}); s != "{3,1,2}" {
+		t.Errorf("Got %s, expected {3,1,2}", s)
+	}
+
+	if s := int64Array(nil); s != "{}" {
+		t.Errorf("Got %s, expected {}", s)
+	}
+}
+
+func TestTextArray(t *testing.T) {
+	if s := textArray([]string{"alice", `say "hi"`, `back\slash`, "a,b"}); s != `{"alice","say \"hi\"","back\\slash","a,b"}` {
+		t.Errorf("Got %s", s)
+	}
+
+	if s := textArray(nil); s != "{}" {
+		t.Errorf("Got %s, expected {}", s)
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Events let other services react to what happens in the forum (new entries,
+edits, deletions, locks, pins and votes) without polling the entry table. Each
+event is written to an outbox table in the same transaction as the change it
+describes, and handed to Config.Events once that transaction commits. Events that could not
+be handed over stay in the outbox until DeliverOutbox succeeds in doing so.
+
+Events are written to the outbox whether or not a sink is configured, and wait
+there until one is. Delivery is at least once: a sink may see the same event
+twice, and should use Event.Id to tell.
+
+For event methods and functions that access a database, see event_db.go
+*/
+package forum
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	EVENT_ENTRY_CREATED  = "entry.created"  //An entry was stored
+	EVENT_ENTRY_EDITED   = "entry.edited"   //An entry's title or body was replaced
+	EVENT_ENTRY_DELETED  = "entry.deleted"  //An entry was soft-deleted
+	EVENT_ENTRY_REMOVED  = "entry.removed"  //An entry and all of its descendants were hard-deleted
+	EVENT_ENTRY_LOCKED   = "entry.locked"   //An entry was locked (Value 1) or unlocked (Value 0)
+	EVENT_ENTRY_PINNED   = "entry.pinned"   //An entry was pinned (Value 1) or unpinned (Value 0)
+	EVENT_VOTE_CAST      = "vote.cast"      //A vote was cast or changed
+	EVENT_VOTE_RETRACTED = "vote.retracted" //A vote was retracted
+
+	OUTBOX_BATCH_SIZE = 100 //Number of events DeliverOutbox hands over per call
+)
+
+type Event struct {
+	Id        int64     `json:"-"` //The ID of this event; increases with every event
+	Kind      string    //One of the EVENT_* constants
+	EntryId   int64     //The ID of the entry the event happened to
+	ActorId   int64     //The ID of the user who caused the event
+	Ancestors []int64   //The IDs of the entry's ancestors, nearest first, for routing
+	Value     int       //For vote events, the value of the vote: one of VOTE_UP, VOTE_DOWN or VOTE_NONE
+	Created   time.Time `json:"-"` //Time at which the event happened
+}
+
+// Passed to Config.DeliveryErrors when a change was stored but its event could
+// not be handed to the EventSink, or could not be marked as delivered afterwards. The event stays in
+// the outbox, so DeliverOutbox will hand it over (possibly again) later.
+type DeliveryError struct {
+	Event *Event //The event that was not delivered
+	Err   error  //Why it was not delivered
+}
+
+func (err *DeliveryError) Error() string {
+	return fmt.Sprintf("Error: The change was saved, but event %d could not be delivered; it will be retried. (%v)", err.Event.Id, err.Err)
+}
+
+func (err *DeliveryError) Unwrap() error {
+	return err.Err
+}
+
+// An EventSink receives events after the change they describe has been
+// committed. Publish should return quickly; an error leaves the event in the
+// outbox to be retried by DeliverOutbox.
+type EventSink interface {
+	Publish(ev *Event) error
+}
@@ -0,0 +1,139 @@
+/*
+Event methods and functions that access a database are placed here.
+*/
+package forum
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+//Writes an event to the outbox in the caller's transaction. The returned event
+//is to be passed to dispatch once the transaction commits. The event is written
+//even if no EventSink is configured yet, so that DeliverOutbox can replay it to
+//one that is attached later.
+func emit(tx *sql.Tx, kind string, entryId, actorId int64, value int) (*Event, error) {
+	ev := &Event{Kind: kind, EntryId: entryId, ActorId: actorId, Value: value, Ancestors: make([]int64, 0)}
+
+	rows, err := tx.Query(queries.AncestorIds, entryId)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ev.Ancestors = append(ev.Ancestors, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.QueryRow(queries.OutboxCreate, kind, entryId, string(payload)).Scan(&ev.Id, &ev.Created); err != nil {
+		return nil, err
+	}
+
+	return ev, nil
+}
+
+//Like emit, but writes nothing, and returns nil, for entries whose authors are
+//shadow-banned: those entries are kept from everyone else, downstream services
+//included, so changes to them are too
+func emitUnlessShadowed(tx *sql.Tx, kind string, entryId, actorId int64, value int) (*Event, error) {
+	shadowed, err := entryShadowed(tx, entryId)
+	if err != nil {
+		return nil, err
+	}
+	if shadowed {
+		return nil, nil
+	}
+
+	return emit(tx, kind, entryId, actorId, value)
+}
+
+//Hands committed events to the EventSink and marks the ones it accepted as
+//delivered. Anything that fails here is left for DeliverOutbox, and passed to
+//Config.DeliveryErrors as a *DeliveryError: the change itself was committed,
+//so it is not the caller's failure.
+func dispatch(events ...*Event) {
+	for _, ev := range events {
+		if ev == nil || Config.Events == nil {
+			continue
+		}
+
+		err := Config.Events.Publish(ev)
+		if err == nil {
+			_, err = Config.DB.Exec(queries.OutboxDelivered, ev.Id)
+		}
+		if err != nil && Config.DeliveryErrors != nil {
+			Config.DeliveryErrors(&DeliveryError{Event: ev, Err: err})
+		}
+	}
+}
+
+// Hands the oldest undelivered events in the outbox to the EventSink, in
+// order, and returns how many it accepted. It stops at the first event the
+// sink refuses, so that events are not delivered out of order. Meant to be
+// called periodically to retry events whose delivery failed.
+func DeliverOutbox() (int, error) {
+	if Config.Events == nil {
+		return 0, nil
+	}
+
+	//Wrap in a transaction, so that concurrent calls do not deliver the same events
+	tx, err := Config.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(queries.OutboxPending, OUTBOX_BATCH_SIZE)
+	if err != nil {
+		return 0, err
+	}
+
+	pending := make([]*Event, 0)
+	for rows.Next() {
+		ev := new(Event)
+		var payload string
+		if err = rows.Scan(&ev.Id, &ev.Created, &payload); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if err = json.Unmarshal([]byte(payload), ev); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		pending = append(pending, ev)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, ev := range pending {
+		if err = Config.Events.Publish(ev); err != nil {
+			break
+		}
+
+		if _, err = tx.Exec(queries.OutboxDelivered, ev.Id); err != nil {
+			break
+		}
+		delivered++
+	}
+
+	if cerr := tx.Commit(); cerr != nil {
+		return 0, cerr
+	}
+
+	return delivered, err
+}
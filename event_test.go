@@ -0,0 +1,36 @@
+package forum
+
+import (
+	"errors"
+	"testing"
+)
+
+type refusingSink struct{}
+
+func (refusingSink) Publish(ev *Event) error {
+	return errors.New("sink is down")
+}
+
+func TestDispatchDeliveryError(t *testing.T) {
+	oldEvents, oldErrors := Config.Events, Config.DeliveryErrors
+	defer func() { Config.Events, Config.DeliveryErrors = oldEvents, oldErrors }()
+
+	var reported []error
+	Config.Events = refusingSink{}
+	Config.DeliveryErrors = func(err error) { reported = append(reported, err) }
+
+	dispatch(&Event{Id: 7, Kind: EVENT_ENTRY_CREATED}, nil)
+
+	if len(reported) != 1 {
+		t.Fatalf("Got %d delivery errors, expected 1", len(reported))
+	}
+
+	var de *DeliveryError
+	if !errors.As(reported[0], &de) || de.Event.Id != 7 {
+		t.Errorf("Got %v, expected a *DeliveryError for event 7", reported[0])
+	}
+
+	//Without a callback, failures are left to DeliverOutbox
+	Config.DeliveryErrors = nil
+	dispatch(&Event{Id: 8})
+}
@@ -0,0 +1,258 @@
+/*
+Feeds let readers follow a forum or a thread from a feed reader. A forum's feed
+holds its newest or hottest posts, and a thread's feed its newest comments. Both
+are available as Atom and as RSS 2.0.
+
+For feed methods and functions that access a database, see feed_db.go
+*/
+package forum
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	FEED_ATOM = "atom" //Atom 1.0, as in RFC 4287
+	FEED_RSS  = "rss"  //RSS 2.0
+
+	FEED_ENTRIES = 25 //Number of entries in a thread's feed; a forum's feed has one page of posts
+)
+
+var errFeedNotFound = errors.New("Error: The feed could not be found.")
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	Id      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Link    []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title     string     `xml:"title"`
+	Id        string     `xml:"id"`
+	Link      atomLink   `xml:"link"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Author    atomAuthor `xml:"author"`
+	Content   atomText   `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Guid        rssGuid `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description"`
+}
+
+type rssGuid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Id          string `xml:",chardata"`
+}
+
+// Builds an Atom document whose title comes from source (a forum or a post)
+// and which holds entries, in the order given. Link returns the URL at which
+// an entry can be read; selfURL is the URL of the feed itself.
+func AtomFeed(source *Entry, entries []*Entry, link func(*Entry) string, selfURL string) ([]byte, error) {
+	f := &atomFeed{
+		Title:   source.Title,
+		Id:      link(source),
+		Updated: feedUpdated(source, entries).Format(time.RFC3339),
+		Link:    []atomLink{{Href: link(source)}, {Href: selfURL, Rel: "self"}},
+		Entries: make([]atomEntry, 0, len(entries)),
+	}
+
+	for _, e := range entries {
+		f.Entries = append(f.Entries, atomEntry{
+			Title:     feedTitle(e),
+			Id:        link(e),
+			Link:      atomLink{Href: link(e)},
+			Published: e.Created.UTC().Format(time.RFC3339),
+			Updated:   entryUpdated(e).Format(time.RFC3339),
+			Author:    atomAuthor{Name: e.AuthorHandle},
+			Content:   atomText{Type: "text", Body: e.Body},
+		})
+	}
+
+	return marshalFeed(f)
+}
+
+// Builds an RSS 2.0 document; see AtomFeed.
+func RSSFeed(source *Entry, entries []*Entry, link func(*Entry) string) ([]byte, error) {
+	f := &rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         source.Title,
+			Link:          link(source),
+			Description:   source.Body,
+			LastBuildDate: feedUpdated(source, entries).Format(time.RFC1123Z),
+			Items:         make([]rssItem, 0, len(entries)),
+		},
+	}
+
+	for _, e := range entries {
+		f.Channel.Items = append(f.Channel.Items, rssItem{
+			Title:       feedTitle(e),
+			Link:        link(e),
+			Guid:        rssGuid{IsPermaLink: true, Id: link(e)},
+			PubDate:     e.Created.UTC().Format(time.RFC1123Z),
+			Description: e.Body,
+		})
+	}
+
+	return marshalFeed(f)
+}
+
+func marshalFeed(f interface{}) ([]byte, error) {
+	body, err := xml.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), body...), nil
+}
+
+//When an entry was last changed: its latest edit, if any, or else its creation
+func entryUpdated(e *Entry) time.Time {
+	if e.Modified.After(e.Created) {
+		return e.Modified.UTC()
+	}
+
+	return e.Created.UTC()
+}
+
+//The time of the latest change to source or any of entries
+func feedUpdated(source *Entry, entries []*Entry) time.Time {
+	updated := entryUpdated(source)
+	for _, e := range entries {
+		if u := entryUpdated(e); u.After(updated) {
+			updated = u
+		}
+	}
+
+	return updated
+}
+
+//Comments have no title of their own, so they are named after their author
+func feedTitle(e *Entry) string {
+	if e.Title != "" {
+		return e.Title
+	}
+
+	return "Comment by " + e.AuthorHandle
+}
+
+// Serves feeds as an http.Handler. The query string says which feed:
+// ?forum=ID for a forum's posts, with &sort=new (the default) or &sort=hot,
+// or ?thread=ID for the newest comments in a thread. Add &format=rss for RSS
+// instead of Atom. Create one with NewFeedHandler.
+type FeedHandler struct {
+	BaseURL string              //The absolute URL at which the handler is served; feeds' own links are built from it
+	Link    func(*Entry) string //Returns the URL at which an entry can be read
+
+	//Retrieve a feed's source and entries; forumFeed and threadFeed unless replaced
+	forum  func(forumId int64, sort string) (*Entry, []*Entry, error)
+	thread func(threadId int64) (*Entry, []*Entry, error)
+}
+
+func NewFeedHandler(baseURL string, link func(*Entry) string) *FeedHandler {
+	return &FeedHandler{BaseURL: baseURL, Link: link, forum: forumFeed, thread: threadFeed}
+}
+
+func (h *FeedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	format := q.Get("format")
+	switch format {
+	case "":
+		format = FEED_ATOM
+	case FEED_ATOM, FEED_RSS:
+	default:
+		http.Error(w, "Error: Unknown feed format '"+format+"'.", http.StatusBadRequest)
+		return
+	}
+
+	//The feed's own URL holds only what was understood of the request, never anything else the client sent
+	self := url.Values{"format": {format}}
+
+	var source *Entry
+	var entries []*Entry
+	var err error
+	if id, perr := strconv.ParseInt(q.Get("forum"), 10, 64); perr == nil {
+		sort := q.Get("sort")
+		if sort == "" {
+			sort = SORT_NEW
+		}
+		if sort != SORT_NEW && sort != SORT_HOT {
+			http.Error(w, "Error: Feeds can only be sorted by new or hot.", http.StatusBadRequest)
+			return
+		}
+
+		self.Set("forum", strconv.FormatInt(id, 10))
+		self.Set("sort", sort)
+		source, entries, err = h.forum(id, sort)
+	} else if id, perr := strconv.ParseInt(q.Get("thread"), 10, 64); perr == nil {
+		self.Set("thread", strconv.FormatInt(id, 10))
+		source, entries, err = h.thread(id)
+	} else {
+		http.Error(w, "Error: Please say which forum or thread to follow.", http.StatusBadRequest)
+		return
+	}
+	if err == errFeedNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Error: We had a database problem trying to build the feed.", http.StatusInternalServerError)
+		return
+	}
+
+	var body []byte
+	if format == FEED_RSS {
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		body, err = RSSFeed(source, entries, h.Link)
+	} else {
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		body, err = AtomFeed(source, entries, h.Link, h.BaseURL+"?"+self.Encode())
+	}
+	if err != nil {
+		http.Error(w, "Error: The feed could not be built.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(body)
+}
@@ -0,0 +1,128 @@
+/*
+Feed methods and functions that access a database are placed here.
+*/
+package forum
+
+import (
+	"database/sql"
+	"time"
+)
+
+//Retrieves a forum and the first page of its posts, leaving out deleted ones.
+//Feeds are read anonymously, so anything hidden from an anonymous viewer
+//(deleted, or by a shadow-banned author) is not found.
+func forumFeed(forumId int64, sort string) (*Entry, []*Entry, error) {
+	forum, err := OneEntry(forumId)
+	if err == sql.ErrNoRows {
+		return nil, nil, errFeedNotFound
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if !forum.Forum || forum.Deleted {
+		return nil, nil, errFeedNotFound
+	}
+
+	posts, err := ForumPosts(forumId, userId(0), sort, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	live := make([]*Entry, 0, len(posts))
+	for _, e := range posts {
+		if !e.Deleted {
+			live = append(live, e)
+		}
+	}
+
+	if err = attachModified(append(live, forum)...); err != nil {
+		return nil, nil, err
+	}
+
+	return forum, live, nil
+}
+
+//Retrieves a post and its newest comments. As with forumFeed, a post that is
+//hidden from an anonymous viewer is not found.
+func threadFeed(threadId int64) (*Entry, []*Entry, error) {
+	post, err := OneEntry(threadId)
+	if err == sql.ErrNoRows {
+		return nil, nil, errFeedNotFound
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if post.Forum || post.Deleted {
+		return nil, nil, errFeedNotFound
+	}
+
+	comments, err := NewestComments(threadId, FEED_ENTRIES)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err = attachModified(append(comments, post)...); err != nil {
+		return nil, nil, err
+	}
+
+	return post, comments, nil
+}
+
+// Retrieves up to limit of the newest comments anywhere beneath an entry,
+// newest first. Deleted comments, and those of shadow-banned authors, are left
+// out. A limit outside 0 to FEED_ENTRIES means FEED_ENTRIES.
+func NewestComments(entryId int64, limit int) ([]*Entry, error) {
+	if limit < 0 || limit > FEED_ENTRIES {
+		limit = FEED_ENTRIES
+	}
+
+	rows, err := Config.DB.Query(queries.NewestComments, entryId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := make([]*Entry, 0, limit)
+	for rows.Next() {
+		e := New()
+		err = rows.Scan(&e.Id, &e.Title, &e.Body, &e.Url, &e.Created, &e.AuthorId, &e.Forum, &e.Deleted, &e.Locked, &e.Pinned, &e.AuthorHandle, &e.Seconds, &e.Upvotes, &e.Downvotes, &e.ParentId)
+		if err != nil {
+			return nil, err
+		}
+
+		comments = append(comments, e)
+	}
+
+	return comments, rows.Err()
+}
+
+//Sets Modified on each of the given entries to the time of its latest edit
+func attachModified(entries ...*Entry) error {
+	ids := make([]int64, len(entries))
+	for i, e := range entries {
+		ids[i] = e.Id
+	}
+
+	rows, err := Config.DB.Query(queries.LastEdits, int64Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	modified := make(map[int64]time.Time, len(ids))
+	for rows.Next() {
+		var id int64
+		var t time.Time
+		if err = rows.Scan(&id, &t); err != nil {
+			return err
+		}
+		modified[id] = t
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		e.Modified = modified[e.Id]
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package forum
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func feedFixture() (*Entry, []*Entry, func(*Entry) string) {
+	created := time.Date(2014, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	forum := &Entry{Id: 1, Title: "Golang", Body: "All about Go", Created: created, Forum: true}
+	entries := []*Entry{
+		{Id: 3, Title: "Generics?", Body: "When <generics>?", Created: created.Add(2 * time.Hour), AuthorHandle: "rob"},
+		{Id: 2, Body: "A comment & more", Created: created.Add(time.Hour), Modified: created.Add(3 * time.Hour), AuthorHandle: "ken"},
+	}
+	link := func(e *Entry) string { return "http://example.com/entry/" + strconv.FormatInt(e.Id, 10) }
+
+	return forum, entries, link
+}
+
+func TestAtomFeed(t *testing.T) {
+	forum, entries, link := feedFixture()
+
+	body, err := AtomFeed(forum, entries, link, "http://example.com/feed?forum=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := new(atomFeed)
+	if err = xml.Unmarshal(body, f); err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Title != "Golang" || f.Id != "http://example.com/entry/1" {
+		t.Errorf("Got title %q and id %q", f.Title, f.Id)
+	}
+	if f.Updated != "2014-03-01T15:00:00Z" {
+		t.Errorf("Got updated %s, expected the latest edit's time", f.Updated)
+	}
+	if len(f.Link) != 2 || f.Link[1].Rel != "self" || f.Link[1].Href != "http://example.com/feed?forum=1" {
+		t.Errorf("Got links %+v", f.Link)
+	}
+
+	if len(f.Entries) != 2 {
+		t.Fatalf("Got %d entries, expected 2", len(f.Entries))
+	}
+	if e := f.Entries[0]; e.Title != "Generics?" || e.Content.Body != "When <generics>?" || e.Author.Name != "rob" || e.Published != "2014-03-01T14:00:00Z" {
+		t.Errorf("Got first entry %+v", e)
+	}
+	if e := f.Entries[1]; e.Title != "Comment by ken" || e.Link.Href != "http://example.com/entry/2" || e.Published != "2014-03-01T13:00:00Z" || e.Updated != "2014-03-01T15:00:00Z" {
+		t.Errorf("Got second entry %+v", e)
+	}
+}
+
+func TestRSSFeed(t *testing.T) {
+	forum, entries, link := feedFixture()
+
+	body, err := RSSFeed(forum, entries, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := new(rssFeed)
+	if err = xml.Unmarshal(body, f); err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Version != "2.0" || f.Channel.Title != "Golang" || f.Channel.Description != "All about Go" {
+		t.Errorf("Got channel %+v", f.Channel)
+	}
+
+	if len(f.Channel.Items) != 2 {
+		t.Fatalf("Got %d items, expected 2", len(f.Channel.Items))
+	}
+	if i := f.Channel.Items[0]; i.Guid.Id != "http://example.com/entry/3" || !i.Guid.IsPermaLink || i.PubDate != "Sat, 01 Mar 2014 14:00:00 +0000" {
+		t.Errorf("Got first item %+v", i)
+	}
+	if i := f.Channel.Items[1]; i.Description != "A comment & more" {
+		t.Errorf("Got second item %+v", i)
+	}
+}
+
+func TestAtomFeedEmpty(t *testing.T) {
+	forum, _, link := feedFixture()
+
+	body, err := AtomFeed(forum, nil, link, "http://example.com/feed?forum=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := new(atomFeed)
+	if err = xml.Unmarshal(body, f); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.Entries) != 0 || f.Updated != "2014-03-01T12:00:00Z" {
+		t.Errorf("Got %d entries updated %s, expected none updated at the forum's creation", len(f.Entries), f.Updated)
+	}
+}
+
+func TestFeedHandler(t *testing.T) {
+	forum, entries, link := feedFixture()
+	post := entries[0]
+
+	h := NewFeedHandler("https://example.com/feed", link)
+	h.forum = func(forumId int64, sort string) (*Entry, []*Entry, error) {
+		if forumId != forum.Id {
+			return nil, nil, errFeedNotFound
+		}
+		return forum, entries, nil
+	}
+	h.thread = func(threadId int64) (*Entry, []*Entry, error) {
+		if threadId != post.Id {
+			return nil, nil, errFeedNotFound
+		}
+		return post, entries[1:], nil
+	}
+
+	cases := []struct {
+		query       string
+		status      int
+		contentType string
+	}{
+		{"?forum=1", http.StatusOK, "application/atom+xml; charset=utf-8"},
+		{"?forum=1&sort=hot&format=rss", http.StatusOK, "application/rss+xml; charset=utf-8"},
+		{"?thread=3&format=atom", http.StatusOK, "application/atom+xml; charset=utf-8"},
+		{"?forum=1&sort=top", http.StatusBadRequest, ""},
+		{"?forum=1&format=json", http.StatusBadRequest, ""},
+		{"?forum=x", http.StatusBadRequest, ""},
+		{"", http.StatusBadRequest, ""},
+		{"?forum=2", http.StatusNotFound, ""},
+		{"?thread=4&format=rss", http.StatusNotFound, ""},
+	}
+
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/feed"+c.query, nil))
+
+		if rec.Code != c.status {
+			t.Errorf("%s: got status %d, expected %d", c.query, rec.Code, c.status)
+			continue
+		}
+		if c.contentType != "" && rec.Header().Get("Content-Type") != c.contentType {
+			t.Errorf("%s: got Content-Type %q, expected %q", c.query, rec.Header().Get("Content-Type"), c.contentType)
+		}
+	}
+}
+
+func TestFeedHandlerDatabaseError(t *testing.T) {
+	_, _, link := feedFixture()
+
+	h := NewFeedHandler("https://example.com/feed", link)
+	h.thread = func(threadId int64) (*Entry, []*Entry, error) {
+		return nil, nil, errors.New("connection refused")
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/feed?thread=3", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Got status %d, expected %d", rec.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestFeedHandlerSelfLink(t *testing.T) {
+	forum, entries, link := feedFixture()
+
+	h := NewFeedHandler("https://example.com/feed", link)
+	h.forum = func(forumId int64, sort string) (*Entry, []*Entry, error) {
+		return forum, entries, nil
+	}
+
+	req := httptest.NewRequest("GET", "/feed?forum=1&utm_source=x", nil)
+	req.Host = "evil.example.net"
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	f := new(atomFeed)
+	if err := xml.Unmarshal(rec.Body.Bytes(), f); err != nil {
+		t.Fatal(err)
+	}
+
+	if self := f.Link[1].Href; self != "https://example.com/feed?format=atom&forum=1&sort=new" {
+		t.Errorf("Got self link %q, expected one built from the base URL", self)
+	}
+}
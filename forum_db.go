@@ -0,0 +1,148 @@
+/*
+A forum is an Entry with Forum set. Forums may be nested inside other forums to
+form sub-forums, and the posts of a forum are its immediate non-forum
+descendants. All of this is expressed through the same closure table that
+holds threads together.
+*/
+package forum
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+const (
+	SORT_HOT = "hot" //Posts with the highest Score first
+	SORT_NEW = "new" //Newest posts first
+	SORT_TOP = "top" //Posts with the most points first
+
+	POSTS_PER_PAGE = 25 //Number of posts on one page of a forum listing
+)
+
+//Creates a forum. If parentId is 0, the forum is top-level; otherwise it
+//becomes a sub-forum of the forum with that ID.
+func CreateForum(title, body string, author User, parentId int64) (*Entry, error) {
+	if parentId != 0 {
+		parent, err := OneEntry(parentId)
+		if err != nil {
+			return nil, errors.New("Error: The parent forum could not be found.")
+		}
+		if !parent.Forum {
+			return nil, errors.New("Error: Sub-forums can only be created inside another forum.")
+		}
+	}
+
+	e := New()
+	e.Title = title
+	e.Body = body
+	e.Author = author
+	e.Forum = true
+
+	if strings.TrimSpace(e.Title) == "" {
+		return nil, errors.New("The Title of a forum must not be empty or consist solely of whitespace.")
+	}
+
+	if err := e.Persist(parentId); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+//Lists the forums that are immediate children of parentId, or all top-level
+//forums if parentId is 0. Forums are ordered by title.
+func ListForums(parentId int64) ([]*Entry, error) {
+	var rows *sql.Rows
+	var err error
+
+	if parentId == 0 {
+		rows, err = Config.DB.Query(queries.TopForums)
+	} else {
+		rows, err = Config.DB.Query(queries.SubForums, parentId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	forums := make([]*Entry, 0)
+	for rows.Next() {
+		e := New()
+		err = rows.Scan(&e.Id, &e.Title, &e.Body, &e.Url, &e.Created, &e.AuthorId, &e.Forum, &e.Deleted, &e.Locked, &e.Pinned, &e.AuthorHandle, &e.Seconds)
+		if err != nil {
+			return nil, err
+		}
+
+		forums = append(forums, e)
+	}
+
+	return forums, rows.Err()
+}
+
+//Retrieves one page (starting from 0) of the posts in a forum, without their
+//comments, so that a front page never has to load whole threads. Each post
+//carries its vote totals, and its ChildCount() reports the number of comments
+//in its thread as counted by the closure table. Sort is one of the SORT_*
+//constants and defaults to SORT_HOT, which orders posts the same way their
+//Score() does. Posts by shadow-banned authors are listed only for their authors.
+func ForumPosts(forumId int64, viewer User, sort string, page int) ([]*Entry, error) {
+	switch sort {
+	case SORT_HOT, SORT_NEW, SORT_TOP:
+	case "":
+		sort = SORT_HOT
+	default:
+		return nil, errors.New("Error: Unknown sort order '" + sort + "'.")
+	}
+
+	if page < 0 {
+		page = 0
+	}
+
+	stmt, err := Config.DB.Prepare(queries.ForumPosts)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(forumId, sort, POSTS_PER_PAGE, page*POSTS_PER_PAGE, viewer.GetId())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := make([]*Entry, 0, POSTS_PER_PAGE)
+	for rows.Next() {
+		e := New()
+		err = rows.Scan(&e.Id, &e.Title, &e.Body, &e.Url, &e.Created, &e.AuthorId, &e.Forum, &e.Deleted, &e.Locked, &e.Pinned, &e.AuthorHandle, &e.Seconds, &e.Upvotes, &e.Downvotes, &e.childCount)
+		if err != nil {
+			return nil, err
+		}
+		e.ParentId = forumId
+		e.hasChildCount = true
+
+		posts = append(posts, e)
+	}
+
+	return posts, rows.Err()
+}
+
+//Anything that can run a query: a *sql.DB or a *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Returns the ID of the nearest forum among an entry and its ancestors, or 0
+// if the entry does not sit under any forum.
+func forumOf(q queryRower, entryId int64) (int64, error) {
+	var forumId int64
+
+	err := q.QueryRow(queries.ForumOf, entryId).Scan(&forumId)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		return 0, errors.New("Error: We had a database problem trying to find the forum of the entry.")
+	}
+
+	return forumId, nil
+}
@@ -0,0 +1,30 @@
+/*
+Karma is a user's reputation: the sum of the Points() of everything they have
+written, kept separately for posts (entries directly inside a forum) and for
+comments (everything else). It is tallied per forum, so that leaderboards can be
+drawn up for any forum and its sub-forums. When entries are moved or merged into
+another forum, the karma they earned moves with them.
+
+For karma methods and functions that access a database, see karma_db.go
+*/
+package forum
+
+const (
+	LEADERBOARD_MAX = 100 //The most users a leaderboard may hold
+)
+
+type UserKarma struct {
+	UserId        int64  //The ID of the user
+	UserHandle    string //Name of the user
+	PostPoints    int64  //Points earned by the user's posts
+	CommentPoints int64  //Points earned by the user's comments
+}
+
+//Total returns all of the points the user has earned
+func (k *UserKarma) Total() int64 {
+	if k == nil {
+		return 0
+	}
+
+	return k.PostPoints + k.CommentPoints
+}
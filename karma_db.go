@@ -0,0 +1,95 @@
+/*
+Karma methods and functions that access a database are placed here.
+*/
+package forum
+
+import (
+	"database/sql"
+	"errors"
+)
+
+//Adds points to the karma of an entry's author, in the forum the entry sits under
+func adjustKarma(tx *sql.Tx, entryId, points int64) error {
+	if points == 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(queries.KarmaAdjust, entryId, points)
+
+	return err
+}
+
+//Adds (sign 1) or removes (sign -1) the points of an entry and all of its
+//descendants to or from their authors' karma, in the forums they now sit under
+//and as the posts or comments they now are
+func shiftKarma(tx *sql.Tx, entryId int64, sign int64) error {
+	_, err := tx.Exec(queries.KarmaShiftSubtree, entryId, sign)
+
+	return err
+}
+
+// Retrieves a user's karma, summed over every forum.
+func Karma(userId int64) (*UserKarma, error) {
+	k := new(UserKarma)
+
+	err := Config.DB.QueryRow(queries.KarmaOfUser, userId).Scan(&k.UserId, &k.UserHandle, &k.PostPoints, &k.CommentPoints)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("Error: The user could not be found.")
+	} else if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// Retrieves the users with the most karma earned within a forum and its
+// sub-forums, most first. A limit outside 1 to LEADERBOARD_MAX means
+// LEADERBOARD_MAX.
+func Leaderboard(forumId int64, limit int) ([]*UserKarma, error) {
+	if limit < 1 || limit > LEADERBOARD_MAX {
+		limit = LEADERBOARD_MAX
+	}
+
+	rows, err := Config.DB.Query(queries.KarmaLeaderboard, forumId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	board := make([]*UserKarma, 0, limit)
+	for rows.Next() {
+		k := new(UserKarma)
+		if err = rows.Scan(&k.UserId, &k.UserHandle, &k.PostPoints, &k.CommentPoints); err != nil {
+			return nil, err
+		}
+
+		board = append(board, k)
+	}
+
+	return board, rows.Err()
+}
+
+// Recomputes all karma from the vote counters of every entry. Must be run once
+// after applying schema.sql to an existing database, following
+// RepairVoteCounts. Karma is kept up to date as votes are cast, so after that
+// this is only needed when votes or entries are changed behind the package's
+// back.
+func RepairKarma() error {
+	//Wrap in a transaction
+	tx, err := Config.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(queries.KarmaDeleteAll); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec(queries.KarmaRecompute); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -9,19 +9,33 @@ func main() {
 
 	forum.Initialize(db)
 }
+
+The tables and columns the package needs beyond entry, entry_closures, vote and
+account are created by schema.sql. After applying it to an existing database,
+call RepairVoteCounts() and then RepairKarma() once.
 */
 package forum
 
 import (
 	"database/sql"
+	"time"
 )
 
 type conf struct {
-	DB *sql.DB //A live database object
+	DB              *sql.DB       //A live database object
+	Fetcher         Fetcher       //Retrieves linked documents for link previews
+	ArchiveAfter    time.Duration //Threads older than this are archived: no more votes or replies. 0 never archives.
+	ForbidSelfVotes bool          //Refuse votes cast by the author of the entry being voted on
+	VoteRateLimit   int           //How many votes a user may cast per VoteRatePeriod. 0 is unlimited.
+	VoteRatePeriod  time.Duration //The period over which VoteRateLimit is counted
+	Events          EventSink     //Receives events about changes to entries and votes. While nil, events wait in the outbox.
+	DeliveryErrors  func(error)   //If set, told of each *DeliveryError; the event is retried by DeliverOutbox
 }
 
 //Create a package-global config object holding needed globals
-var Config *conf = &conf{}
+var Config *conf = &conf{
+	Fetcher: &HTTPFetcher{},
+}
 
 //Niladic function to setup the forum
 func Initialize(db *sql.DB) {
@@ -0,0 +1,80 @@
+/*
+Mentions let an author call someone into a discussion by writing their handle
+after an @ sign, as in "@alice". Mentioned users are notified, and the resolved
+mentions are kept on the entry so that renderers can link them. Editing an entry
+updates its mentions, and notifies only the users who were not mentioned before.
+
+For mention methods and functions that access a database, see mention_db.go
+*/
+package forum
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	MAX_MENTIONS = 20 //Mentions beyond this many in one entry are ignored
+)
+
+type Mention struct {
+	UserId int64  //The ID of the mentioned user
+	Handle string //Name of the mentioned user, as it appears after the @ in the body
+}
+
+// Returns the handles mentioned in body, in the order they first appear and
+// without duplicates. A mention is an @ followed by letters, digits or
+// underscores. The @ must not follow a letter, digit or one of `_@./`, so that
+// e-mail addresses and URLs are not taken for mentions.
+func ParseMentions(body string) []string {
+	handles := make([]string, 0)
+	seen := make(map[string]bool)
+
+	prev := ' '
+	for i := 0; i < len(body); {
+		r, size := utf8.DecodeRuneInString(body[i:])
+		if r != '@' || isHandleRune(prev) || prev == '@' || prev == '.' || prev == '/' {
+			prev = r
+			i += size
+			continue
+		}
+
+		//Consume the handle following the @
+		start := i + size
+		end := start
+		for end < len(body) {
+			hr, hsize := utf8.DecodeRuneInString(body[end:])
+			if !isHandleRune(hr) {
+				break
+			}
+			end += hsize
+		}
+
+		if handle := body[start:end]; handle != "" && !seen[handle] && len(handles) < MAX_MENTIONS {
+			seen[handle] = true
+			handles = append(handles, handle)
+		}
+
+		prev = '@'
+		if end > start {
+			prev, _ = utf8.DecodeLastRuneInString(body[start:end])
+		}
+		i = end
+	}
+
+	return handles
+}
+
+//Returns the IDs of the users in e.Mentions
+func (e *Entry) mentionedIds() []int64 {
+	ids := make([]int64, 0, len(e.Mentions))
+	for _, m := range e.Mentions {
+		ids = append(ids, m.UserId)
+	}
+
+	return ids
+}
+
+func isHandleRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,116 @@
+/*
+Mention methods and functions that access a database are placed here.
+*/
+package forum
+
+import (
+	"database/sql"
+)
+
+//Stores a row for each account mentioned in the entry's body and sets
+//e.Mentions to them. Handles that match no account are ignored.
+func (e *Entry) storeMentions(tx *sql.Tx) error {
+	e.Mentions = make([]*Mention, 0)
+
+	handles := ParseMentions(e.Body)
+	if len(handles) == 0 {
+		return nil
+	}
+
+	rows, err := tx.Query(queries.MentionsCreate, e.Id, textArray(handles))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		m := new(Mention)
+		if err = rows.Scan(&m.UserId, &m.Handle); err != nil {
+			return err
+		}
+
+		e.Mentions = append(e.Mentions, m)
+	}
+
+	return rows.Err()
+}
+
+//Replaces the mentions stored for an edited entry with those in its current
+//body. Users that the edit newly mentions are notified on behalf of by, unless
+//the entry's author is shadow-banned. Neither the author nor by is notified.
+func (e *Entry) replaceMentions(tx *sql.Tx, by User) error {
+	rows, err := tx.Query(queries.MentionsForEntryDelete, e.Id)
+	if err != nil {
+		return err
+	}
+
+	excluded := []int64{e.AuthorId, by.GetId()}
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+
+		excluded = append(excluded, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if err = e.storeMentions(tx); err != nil || len(e.Mentions) == 0 {
+		return err
+	}
+
+	shadowed, err := entryShadowed(tx, e.Id)
+	if err != nil || shadowed {
+		return err
+	}
+
+	_, err = tx.Exec(queries.MentionNotificationsCreate, int64Array(e.mentionedIds()), e.Id, by.GetId(), NOTIFY_MENTION, int64Array(excluded))
+
+	return err
+}
+
+//Sets Mentions on each of the given entries, and on all of their loaded
+//descendants. Entries may be the roots of trees or a flat list.
+func AttachMentions(entries ...*Entry) error {
+	ids := make([]int64, 0, len(entries))
+	for _, root := range entries {
+		root.Walk(func(e *Entry) { ids = append(ids, e.Id) })
+	}
+
+	mentions := make(map[int64][]*Mention, len(ids))
+	if len(ids) > 0 {
+		rows, err := Config.DB.Query(queries.MentionsForEntries, int64Array(ids))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var entryId int64
+			m := new(Mention)
+			if err = rows.Scan(&entryId, &m.UserId, &m.Handle); err != nil {
+				return err
+			}
+
+			mentions[entryId] = append(mentions[entryId], m)
+		}
+		if err = rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	for _, root := range entries {
+		root.Walk(func(e *Entry) {
+			e.Mentions = mentions[e.Id]
+			if e.Mentions == nil {
+				e.Mentions = make([]*Mention, 0)
+			}
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,41 @@
+package forum
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	cases := []struct {
+		body string
+		want []string
+	}{
+		{"", []string{}},
+		{"No mentions here.", []string{}},
+		{"@alice", []string{"alice"}},
+		{"Thanks @alice and @bob_2!", []string{"alice", "bob_2"}},
+		{"@alice, @bob: see (@carol).", []string{"alice", "bob", "carol"}},
+		{"@alice @bob @alice", []string{"alice", "bob"}},
+		{"Mail me at alice@example.com", []string{}},
+		{"See https://example.com/@alice and @@bob", []string{}},
+		{"A lone @ sign", []string{}},
+		{"Hello @élodie.", []string{"élodie"}},
+	}
+
+	for _, c := range cases {
+		if got := ParseMentions(c.body); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ParseMentions(%q) = %q, expected %q", c.body, got, c.want)
+		}
+	}
+}
+
+func TestParseMentionsLimit(t *testing.T) {
+	body := ""
+	for i := 0; i < MAX_MENTIONS+5; i++ {
+		body += " @user" + string(rune('a'+i))
+	}
+
+	if got := len(ParseMentions(body)); got != MAX_MENTIONS {
+		t.Errorf("Got %d mentions, expected %d", got, MAX_MENTIONS)
+	}
+}
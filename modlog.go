@@ -0,0 +1,43 @@
+/*
+The moderation log is an append-only record of every moderation action: who did
+what to which entry, and what it looked like before and after. Log entries are
+written in the same transaction as the action they describe, so an action
+cannot happen without being recorded. Edits and deletions do not quote the text
+they replaced; they refer to the Delta that keeps it, so that removing the
+entry's history removes the text everywhere.
+
+For log methods and functions that access a database, see modlog_db.go
+*/
+package forum
+
+import (
+	"time"
+)
+
+const (
+	LOG_DELETE      = "delete"      //An entry was soft-deleted by someone other than its author
+	LOG_HARD_DELETE = "hard_delete" //An entry and all of its replies were removed permanently
+	LOG_EDIT        = "edit"        //An entry was edited by someone other than its author
+	LOG_MOVE        = "move"        //An entry was moved to a new parent
+	LOG_MERGE       = "merge"       //A thread was merged into another
+	LOG_LOCK        = "lock"        //An entry was locked or unlocked
+	LOG_PIN         = "pin"         //An entry was pinned or unpinned
+	LOG_VOTE_RESET  = "vote_reset"  //All votes on an entry were removed
+	LOG_RESOLVE     = "resolve"     //The reports against an entry were resolved
+	LOG_BAN         = "ban"         //A user was banned from a forum
+	LOG_UNBAN       = "unban"       //A user's bans from a forum were lifted
+
+	LOG_ENTRIES_PER_PAGE = 50 //Number of actions on one page of the moderation log
+)
+
+type ModAction struct {
+	Id          int64     //The ID of this log entry
+	ForumId     int64     //The ID of the forum the entry sat under, or 0
+	ActorId     int64     //The ID of the moderator who took the action
+	ActorHandle string    //Name of the moderator who took the action
+	EntryId     int64     //The ID of the entry that was acted upon
+	Action      string    //One of the LOG_* constants
+	Before      string    //A description of the entry before the action
+	After       string    //A description of the entry after the action
+	Created     time.Time //Time at which the action was taken
+}
@@ -0,0 +1,60 @@
+/*
+Moderation log methods and functions that access a database are placed here.
+*/
+package forum
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// Appends a moderation action to the log, scoped to the forum that the entry
+// sits under. Call it before any change that would detach the entry from its
+// forum.
+func logAction(tx *sql.Tx, actor User, entryId int64, action, before, after string) error {
+	forumId, err := forumOf(tx, entryId)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(queries.ModLogCreate, forumId, actor.GetId(), entryId, action, before, after); err != nil {
+		return errors.New("Error: The moderation action could not be logged, so it was not carried out.")
+	}
+
+	return nil
+}
+
+// Retrieves one page (starting from 0) of the moderation log of a forum and its sub-forums.
+func ForumModLog(forumId int64, page int) ([]*ModAction, error) {
+	return getModLog(queries.ModLogByForum, forumId, page)
+}
+
+// Retrieves one page (starting from 0) of the moderation actions taken by one moderator.
+func ModeratorModLog(actorId int64, page int) ([]*ModAction, error) {
+	return getModLog(queries.ModLogByActor, actorId, page)
+}
+
+func getModLog(query string, id int64, page int) ([]*ModAction, error) {
+	if page < 0 {
+		page = 0
+	}
+
+	rows, err := Config.DB.Query(query, id, LOG_ENTRIES_PER_PAGE, page*LOG_ENTRIES_PER_PAGE)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	log := make([]*ModAction, 0)
+	for rows.Next() {
+		a := new(ModAction)
+		err = rows.Scan(&a.Id, &a.ForumId, &a.ActorId, &a.ActorHandle, &a.EntryId, &a.Action, &a.Before, &a.After, &a.Created)
+		if err != nil {
+			return nil, err
+		}
+
+		log = append(log, a)
+	}
+
+	return log, rows.Err()
+}
@@ -0,0 +1,36 @@
+/*
+Notifications tell a user that something happened that concerns them, such as
+someone replying to one of their entries. They are created in the same
+transaction as the entry that caused them, and collected in the user's inbox.
+
+For notification methods and functions that access a database, see notification_db.go
+*/
+package forum
+
+import (
+	"time"
+)
+
+const (
+	NOTIFY_REPLY        = "reply"        //Someone replied to one of the user's entries
+	NOTIFY_MENTION      = "mention"      //Someone mentioned the user in an entry
+	NOTIFY_SUBSCRIPTION = "subscription" //Someone posted beneath an entry the user follows
+
+	NOTIFICATIONS_PER_PAGE = 50 //Number of notifications on one page of an inbox
+)
+
+type Notification struct {
+	Id          int64     //The ID of this notification
+	UserId      int64     //The ID of the user being notified
+	EntryId     int64     //The ID of the entry that caused the notification, e.g. the reply
+	ActorId     int64     //The ID of the user who caused the notification, e.g. the reply's author
+	ActorHandle string    //Name of the user who caused the notification
+	Kind        string    //One of the NOTIFY_* constants
+	Created     time.Time //Time at which the notification was created
+	Read        bool      //Has the user seen this notification?
+
+	//Fields beneath this line are not persisted to the notification table
+
+	EntryTitle   string //Title of the entry that caused the notification
+	EntrySnippet string //The start of the body of the entry that caused the notification
+}
@@ -0,0 +1,109 @@
+/*
+Notification methods and functions that access a database are placed here.
+*/
+package forum
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// Creates the notifications caused by a newly stored entry, in the transaction
+// that stored it. The author of the entry's parent is told about the reply,
+// unless the parent is a forum, each user in e.Mentions is told they were
+// mentioned, and everyone following an ancestor is told of the new entry.
+// Nobody is notified twice for one entry, authors are never notified of their
+// own entries, and nobody is notified of entries by shadow-banned authors.
+func (e *Entry) notify(tx *sql.Tx, parent *Entry, shadow bool) error {
+	if shadow {
+		return nil
+	}
+
+	//Each statement leaves out everyone notified by the ones before it
+	excluded := []int64{0, e.AuthorId}
+
+	if parent != nil && !parent.Forum && parent.AuthorId != 0 && parent.AuthorId != e.AuthorId {
+		if err := notify(tx, parent.AuthorId, e.Id, e.AuthorId, NOTIFY_REPLY); err != nil {
+			return err
+		}
+		excluded = append(excluded, parent.AuthorId)
+	}
+
+	if len(e.Mentions) > 0 {
+		mentioned := e.mentionedIds()
+		if _, err := tx.Exec(queries.MentionNotificationsCreate, int64Array(mentioned), e.Id, e.AuthorId, NOTIFY_MENTION, int64Array(excluded)); err != nil {
+			return err
+		}
+		excluded = append(excluded, mentioned...)
+	}
+
+	_, err := tx.Exec(queries.SubscriptionNotificationsCreate, e.Id, e.AuthorId, NOTIFY_SUBSCRIPTION, int64Array(excluded))
+
+	return err
+}
+
+//Records one notification for userId
+func notify(tx *sql.Tx, userId, entryId, actorId int64, kind string) error {
+	_, err := tx.Exec(queries.NotificationCreate, userId, entryId, actorId, kind)
+
+	return err
+}
+
+// Retrieves one page (starting from 0) of a user's notifications, newest
+// first. If unreadOnly is set, notifications already marked as read are left
+// out. Notifications about entries that have since been deleted are never shown.
+func Inbox(user User, unreadOnly bool, page int) ([]*Notification, error) {
+	if page < 0 {
+		page = 0
+	}
+
+	rows, err := Config.DB.Query(queries.Inbox, user.GetId(), unreadOnly, NOTIFICATIONS_PER_PAGE, page*NOTIFICATIONS_PER_PAGE, SNIPPET_LENGTH)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	inbox := make([]*Notification, 0)
+	for rows.Next() {
+		n := new(Notification)
+		err = rows.Scan(&n.Id, &n.UserId, &n.EntryId, &n.ActorId, &n.ActorHandle, &n.Kind, &n.Created, &n.Read, &n.EntryTitle, &n.EntrySnippet)
+		if err != nil {
+			return nil, err
+		}
+
+		inbox = append(inbox, n)
+	}
+
+	return inbox, rows.Err()
+}
+
+// Returns the number of notifications the user has not yet read.
+func UnreadCount(user User) (int64, error) {
+	var count int64
+	err := Config.DB.QueryRow(queries.NotificationsUnread, user.GetId()).Scan(&count)
+
+	return count, err
+}
+
+// Marks the given notifications as read. Notifications that belong to
+// someone other than user are left alone.
+func MarkRead(user User, ids ...int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if _, err := Config.DB.Exec(queries.NotificationsMarkRead, user.GetId(), int64Array(ids)); err != nil {
+		return errors.New("Error: We had a database problem trying to mark your notifications as read.")
+	}
+
+	return nil
+}
+
+// Marks every one of the user's notifications as read.
+func MarkAllRead(user User) error {
+	if _, err := Config.DB.Exec(queries.NotificationsMarkRead, user.GetId(), nil); err != nil {
+		return errors.New("Error: We had a database problem trying to mark your notifications as read.")
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+/*
+Permission checks and moderator appointments that access a database are placed
+here. See user.go for the interfaces a User may implement to take part.
+*/
+package forum
+
+import (
+	"errors"
+)
+
+// Returns a *PermissionError unless by may perform action on e. The entry may
+// be nil when the action has no target, e.g. creating a top-level entry.
+func authorize(q queryRower, by User, action string, e *Entry) error {
+	var entryId int64
+	if e != nil {
+		entryId = e.Id
+	}
+	denied := &PermissionError{Action: action, EntryId: entryId, UserId: by.GetId()}
+
+	if a, ok := by.(Authorizer); ok {
+		if a.Can(action, e) {
+			return nil
+		}
+		return denied
+	}
+
+	switch action {
+	case ACTION_POST, ACTION_VOTE:
+		return nil
+	case ACTION_EDIT, ACTION_DELETE:
+		if e != nil && e.AuthorId == by.GetId() {
+			return nil
+		}
+	}
+
+	moderator, err := isModerator(q, by, entryId)
+	if err != nil {
+		return err
+	}
+	if !moderator {
+		return denied
+	}
+
+	return nil
+}
+
+// Whether u moderates the entry with ID entryId, either as an Administrator or
+// by appointment to a forum among the entry and its ancestors.
+func IsModerator(u User, entryId int64) (bool, error) {
+	return isModerator(Config.DB, u, entryId)
+}
+
+func isModerator(q queryRower, u User, entryId int64) (bool, error) {
+	if a, ok := u.(Administrator); ok && a.IsAdmin() {
+		return true, nil
+	}
+
+	if entryId == 0 {
+		return false, nil
+	}
+
+	var moderator bool
+	if err := q.QueryRow(queries.IsModerator, entryId, u.GetId()).Scan(&moderator); err != nil {
+		return false, errors.New("Error: We had a database problem trying to check your permissions.")
+	}
+
+	return moderator, nil
+}
+
+// Appoints the user with ID userId to moderate a forum and all of its
+// sub-forums. Only moderators of the forum may appoint others.
+func AddModerator(forumId, userId int64, by User) error {
+	if err := authorizeAppointment(forumId, by); err != nil {
+		return err
+	}
+
+	if _, err := Config.DB.Exec(queries.ModeratorCreate, forumId, userId, by.GetId()); err != nil {
+		return errors.New("Error: We had a database problem trying to appoint the moderator.")
+	}
+
+	return nil
+}
+
+// Dismisses the user with ID userId as moderator of a forum. Appointments to
+// the forum's ancestors or sub-forums are unaffected.
+func RemoveModerator(forumId, userId int64, by User) error {
+	if err := authorizeAppointment(forumId, by); err != nil {
+		return err
+	}
+
+	if _, err := Config.DB.Exec(queries.ModeratorDelete, forumId, userId); err != nil {
+		return errors.New("Error: We had a database problem trying to dismiss the moderator.")
+	}
+
+	return nil
+}
+
+func authorizeAppointment(forumId int64, by User) error {
+	forum, err := entryState(Config.DB, queries.EntryState, forumId)
+	if err != nil {
+		return err
+	}
+	if !forum.Forum {
+		return errors.New("Error: Moderators can only be appointed to forums.")
+	}
+
+	return authorize(Config.DB, by, ACTION_APPOINT, forum)
+}
@@ -0,0 +1,350 @@
+/*
+Link previews describe the document that a link entry points to. When an Entry
+has Url set, its Body holds the address of the linked document. The document is
+retrieved through a Fetcher so that tests (or callers with their own crawling
+infrastructure) can substitute their own implementation.
+
+For preview methods that access the database, see preview_db.go
+*/
+package forum
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	PREVIEW_MAX_BYTES     = 512 * 1024 //Only this much of a linked document is read when extracting a preview
+	PREVIEW_MAX_REDIRECTS = 5          //How many redirects HTTPFetcher follows before giving up
+)
+
+var ErrForbiddenAddress = errors.New("Error: Links to local or private network addresses cannot be previewed.")
+
+type LinkPreview struct {
+	EntryId     int64     //The ID of the entry whose link was previewed
+	Url         string    //The address that was fetched
+	Title       string    //Title of the linked document
+	Description string    //Short description of the linked document
+	Image       string    //Absolute address of a representative image
+	Canonical   string    //Absolute canonical address of the linked document
+	Fetched     time.Time //Time at which the document was fetched
+}
+
+//A Fetcher retrieves the document found at an address, decoded to UTF-8. The
+//caller closes the result.
+type Fetcher interface {
+	Fetch(link string) (io.ReadCloser, error)
+}
+
+//HTTPFetcher is the default Fetcher, retrieving documents over HTTP(S). Links
+//are posted by users, so the client made by NewHTTPFetcher refuses to connect to
+//loopback, private, link-local and other non-public addresses. The check is made
+//on the address actually dialed, after DNS resolution, so it holds for every
+//redirect as well. A caller that sets Client itself takes over that duty.
+type HTTPFetcher struct {
+	Client *http.Client
+}
+
+//NewHTTPFetcher returns an HTTPFetcher whose requests time out after timeout and
+//which only connects to public addresses
+func NewHTTPFetcher(timeout time.Duration) *HTTPFetcher {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			ap, err := netip.ParseAddrPort(address)
+			if err != nil || !publicIP(ap.Addr()) {
+				return ErrForbiddenAddress
+			}
+			return nil
+		},
+	}
+
+	return &HTTPFetcher{Client: &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			//No proxy: it would be dialed instead of the linked host
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= PREVIEW_MAX_REDIRECTS {
+				return fmt.Errorf("Error: %s redirected too many times.", via[0].URL)
+			}
+			return checkLink(req.URL)
+		},
+	}}
+}
+
+func (f *HTTPFetcher) Fetch(link string) (io.ReadCloser, error) {
+	client := f.Client
+	if client == nil {
+		client = defaultFetcher.Client
+	}
+
+	resp, err := client.Get(link)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Error: %s responded with status %d.", link, resp.StatusCode)
+	}
+
+	ct := resp.Header.Get("Content-Type")
+	if ct != "" && !strings.Contains(ct, "html") {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Error: %s is not an HTML document.", link)
+	}
+
+	//The charset comes from the header, a BOM or a <meta> tag, in that order
+	r, err := charset.NewReader(resp.Body, ct)
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Error: %s is in an unsupported character set.", link)
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, resp.Body}, nil
+}
+
+//Used by an HTTPFetcher that has no Client of its own
+var defaultFetcher = NewHTTPFetcher(10 * time.Second)
+
+//Address ranges that links may not lead into: local, private, shared, reserved
+//and multicast networks, and the IPv6 ranges that embed IPv4 addresses
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       //"This" network
+	netip.MustParsePrefix("10.0.0.0/8"),      //Private
+	netip.MustParsePrefix("100.64.0.0/10"),   //Carrier-grade NAT, including cloud metadata services
+	netip.MustParsePrefix("127.0.0.0/8"),     //Loopback
+	netip.MustParsePrefix("169.254.0.0/16"),  //Link-local, including cloud metadata services
+	netip.MustParsePrefix("172.16.0.0/12"),   //Private
+	netip.MustParsePrefix("192.0.0.0/24"),    //IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    //Documentation
+	netip.MustParsePrefix("192.88.99.0/24"),  //6to4 relay anycast
+	netip.MustParsePrefix("192.168.0.0/16"),  //Private
+	netip.MustParsePrefix("198.18.0.0/15"),   //Benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), //Documentation
+	netip.MustParsePrefix("203.0.113.0/24"),  //Documentation
+	netip.MustParsePrefix("224.0.0.0/4"),     //Multicast
+	netip.MustParsePrefix("240.0.0.0/4"),     //Reserved, including broadcast
+	netip.MustParsePrefix("::/128"),          //Unspecified
+	netip.MustParsePrefix("::1/128"),         //Loopback
+	netip.MustParsePrefix("64:ff9b::/96"),    //NAT64
+	netip.MustParsePrefix("64:ff9b:1::/48"),  //Local-use NAT64
+	netip.MustParsePrefix("100::/64"),        //Discard
+	netip.MustParsePrefix("2001::/23"),       //IETF protocol assignments, including Teredo
+	netip.MustParsePrefix("2001:db8::/32"),   //Documentation
+	netip.MustParsePrefix("2002::/16"),       //6to4
+	netip.MustParsePrefix("fc00::/7"),        //Unique local
+	netip.MustParsePrefix("fe80::/10"),       //Link-local
+	netip.MustParsePrefix("ff00::/8"),        //Multicast
+}
+
+//Reports whether ip is an ordinary public unicast address
+func publicIP(ip netip.Addr) bool {
+	if !ip.IsValid() {
+		return false
+	}
+
+	//IPv4-mapped IPv6 addresses are judged as the IPv4 addresses they carry
+	ip = ip.Unmap()
+	for _, p := range nonPublicPrefixes {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+//Make sure link is an absolute http(s) address that does not name a
+//non-public IP outright. Host names are checked when they are dialed.
+func checkLink(u *url.URL) error {
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("Error: Only absolute http and https links can be previewed.")
+	}
+
+	if ip, err := netip.ParseAddr(u.Hostname()); err == nil && !publicIP(ip) {
+		return ErrForbiddenAddress
+	}
+
+	return nil
+}
+
+//Link returns the address that a link entry points to, or "" if the entry is not a link
+func (e *Entry) Link() string {
+	if e == nil || !e.Url {
+		return ""
+	}
+
+	return strings.TrimSpace(e.Body)
+}
+
+//FetchPreview retrieves the document at link with Config.Fetcher and extracts its preview metadata
+func FetchPreview(link string) (*LinkPreview, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, errors.New("Error: Only absolute http and https links can be previewed.")
+	}
+	if err = checkLink(u); err != nil {
+		return nil, err
+	}
+
+	if Config.Fetcher == nil {
+		return nil, errors.New("Error: No Fetcher has been configured for link previews.")
+	}
+
+	body, err := Config.Fetcher.Fetch(link)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	p, err := ExtractPreview(io.LimitReader(body, PREVIEW_MAX_BYTES), link)
+	if err != nil {
+		return nil, err
+	}
+	p.Fetched = time.Now()
+
+	return p, nil
+}
+
+//ExtractPreview parses the head of a UTF-8 HTML document found at link. The
+//title, description, Open Graph image and canonical URL are collected; Open
+//Graph values take precedence over their plain HTML counterparts. Relative
+//addresses are resolved against link. Malformed markup ends the parse early but
+//whatever was found up to that point is still returned.
+func ExtractPreview(r io.Reader, link string) (*LinkPreview, error) {
+	base, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &LinkPreview{Url: link}
+	var htmlTitle, htmlDescription, ogTitle, ogDescription, ogUrl string
+
+	z := html.NewTokenizer(r)
+	inTitle := false
+Parse:
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			//io.EOF or broken markup: keep what we have
+			break
+		}
+
+		t := z.Token()
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			switch t.DataAtom {
+			case atom.Body:
+				break Parse
+			case atom.Title:
+				inTitle = tt == html.StartTagToken
+			case atom.Meta:
+				key := strings.ToLower(attr(t, "property"))
+				if key == "" {
+					key = strings.ToLower(attr(t, "name"))
+				}
+				content := strings.TrimSpace(attr(t, "content"))
+
+				switch key {
+				case "og:title":
+					ogTitle = content
+				case "og:description":
+					ogDescription = content
+				case "og:image", "og:image:url":
+					if p.Image == "" {
+						p.Image = resolve(base, content)
+					}
+				case "og:url":
+					ogUrl = content
+				case "description":
+					htmlDescription = content
+				}
+			case atom.Link:
+				for _, rel := range strings.Fields(strings.ToLower(attr(t, "rel"))) {
+					if rel == "canonical" {
+						p.Canonical = resolve(base, attr(t, "href"))
+					}
+				}
+			}
+		case html.EndTagToken:
+			switch t.DataAtom {
+			case atom.Head:
+				break Parse
+			case atom.Title:
+				inTitle = false
+			}
+		case html.TextToken:
+			if inTitle {
+				htmlTitle += t.Data
+			}
+		}
+	}
+
+	p.Title = firstNonEmpty(ogTitle, collapseSpace(htmlTitle))
+	p.Description = firstNonEmpty(ogDescription, htmlDescription)
+	if p.Canonical == "" && ogUrl != "" {
+		p.Canonical = resolve(base, ogUrl)
+	}
+
+	return p, nil
+}
+
+//Return the value of the named attribute of a tag, if present
+func attr(t html.Token, name string) string {
+	for _, a := range t.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+
+	return ""
+}
+
+//Resolve a possibly-relative reference against base. Returns "" if it cannot be parsed.
+func resolve(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+
+	return base.ResolveReference(u).String()
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
+
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
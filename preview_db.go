@@ -0,0 +1,56 @@
+/*
+Link preview methods and functions that access a database are placed here.
+*/
+package forum
+
+import (
+	"errors"
+)
+
+//Fetches the document that a link entry points to, extracts its preview
+//metadata and stores it alongside the entry, replacing any earlier preview.
+func (e *Entry) RefreshPreview() (*LinkPreview, error) {
+	if e.Id == 0 || !e.Url {
+		return nil, errors.New("Error: Only saved link entries can have previews.")
+	}
+
+	p, err := FetchPreview(e.Link())
+	if err != nil {
+		return nil, err
+	}
+	p.EntryId = e.Id
+
+	stmt, err := Config.DB.Prepare(queries.PreviewUpsert)
+	if err != nil {
+		return nil, errors.New("Error: We had a database problem trying to store the link preview.")
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(p.EntryId, p.Url, p.Title, p.Description, p.Image, p.Canonical, p.Fetched)
+	if err != nil {
+		return nil, errors.New("Error: The link preview could not be stored.")
+	}
+
+	e.Preview = p
+
+	return p, nil
+}
+
+//Retrieve the stored preview of an entry's link, if any.
+func FindPreview(entryId int64) (*LinkPreview, bool) {
+	p := new(LinkPreview)
+
+	stmt, err := Config.DB.Prepare(queries.FindPreview)
+	if err != nil {
+		return p, false
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRow(entryId).Scan(&p.EntryId, &p.Url, &p.Title, &p.Description, &p.Image, &p.Canonical, &p.Fetched)
+	if err != nil {
+		p = new(LinkPreview)
+		return p, false
+	}
+
+	return p, true
+}
@@ -0,0 +1,177 @@
+package forum
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+type stubFetcher map[string]string
+
+func (f stubFetcher) Fetch(link string) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader(f[link])), nil
+}
+
+const previewDocument = `<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>
+		Plain   title &amp; more
+	</title>
+	<meta name="description" content="Plain description">
+	<meta property="og:image" content="/img/lead.png">
+	<link rel="canonical" href="/story/1">
+	<script>var x = 1;</script>
+</head>
+<body>
+	<title>Not the title</title>
+</body>
+</html>`
+
+func TestExtractPreview(t *testing.T) {
+	p, err := ExtractPreview(strings.NewReader(previewDocument), "http://example.com/story/1?ref=feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := LinkPreview{
+		Url:         "http://example.com/story/1?ref=feed",
+		Title:       "Plain title & more",
+		Description: "Plain description",
+		Image:       "http://example.com/img/lead.png",
+		Canonical:   "http://example.com/story/1",
+	}
+
+	if *p != expected {
+		t.Errorf("Got %+v, expected %+v", *p, expected)
+	}
+}
+
+func TestExtractPreviewOpenGraph(t *testing.T) {
+	doc := `<html><head><title>Plain</title>
+<meta name="description" content="Plain description">
+<meta property="og:title" content="Graph title">
+<meta property="og:description" content="Graph description">
+<meta property="og:url" content="https://example.org/canonical">
+</head></html>`
+
+	p, err := ExtractPreview(strings.NewReader(doc), "https://example.org/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Title != "Graph title" || p.Description != "Graph description" || p.Canonical != "https://example.org/canonical" {
+		t.Errorf("Open Graph values were not preferred: %+v", *p)
+	}
+}
+
+func TestFetchPreview(t *testing.T) {
+	old := Config.Fetcher
+	defer func() { Config.Fetcher = old }()
+
+	Config.Fetcher = stubFetcher{"http://example.com/story/1": previewDocument}
+
+	p, err := FetchPreview("http://example.com/story/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Title != "Plain title & more" || p.Fetched.IsZero() {
+		t.Errorf("Unexpected preview %+v", *p)
+	}
+
+	if _, err := FetchPreview("javascript:alert(1)"); err == nil {
+		t.Error("Expected non-http links to be rejected")
+	}
+}
+
+func TestPublicIP(t *testing.T) {
+	cases := map[string]bool{
+		"93.184.216.34":        true,
+		"2606:2800:220::1":     true,
+		"127.0.0.1":            false,
+		"::1":                  false,
+		"10.1.2.3":             false,
+		"172.16.0.1":           false,
+		"192.168.1.1":          false,
+		"169.254.169.254":      false,
+		"100.100.100.200":      false,
+		"0.1.2.3":              false,
+		"198.18.0.1":           false,
+		"240.0.0.1":            false,
+		"255.255.255.255":      false,
+		"fe80::1":              false,
+		"fd00::1":              false,
+		"0.0.0.0":              false,
+		"::ffff:127.0.0.1":     false,
+		"64:ff9b::a00:1":       false,
+		"2002:a00:1::1":        false,
+		"224.0.0.1":            false,
+		"ff02::1":              false,
+		"::ffff:93.184.216.34": true,
+	}
+
+	for addr, expected := range cases {
+		if got := publicIP(netip.MustParseAddr(addr)); got != expected {
+			t.Errorf("publicIP(%s) = %v, expected %v", addr, got, expected)
+		}
+	}
+}
+
+func TestFetchPreviewForbiddenAddress(t *testing.T) {
+	old := Config.Fetcher
+	defer func() { Config.Fetcher = old }()
+
+	Config.Fetcher = stubFetcher{}
+
+	for _, link := range []string{"http://127.0.0.1/", "http://[::1]:8080/", "http://169.254.169.254/latest/meta-data/"} {
+		if _, err := FetchPreview(link); err != ErrForbiddenAddress {
+			t.Errorf("FetchPreview(%s) returned %v, expected ErrForbiddenAddress", link, err)
+		}
+	}
+}
+
+func TestHTTPFetcherRefusesLocalHosts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, previewDocument)
+	}))
+	defer srv.Close()
+
+	//Resolved by name, so only the dial-time check can catch it
+	link := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+
+	if _, err := NewHTTPFetcher(time.Second).Fetch(link); !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("Expected a link to localhost to be refused, got %v", err)
+	}
+}
+
+func TestHTTPFetcherCharset(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		io.WriteString(w, "<html><head><title>Caf\xe9 cr\xe8me</title></head></html>")
+	}))
+	defer srv.Close()
+
+	//The test server is local, so use its own client
+	body, err := (&HTTPFetcher{Client: srv.Client()}).Fetch(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+
+	p, err := ExtractPreview(body, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Title != "Café crème" {
+		t.Errorf("Got title %q, expected %q", p.Title, "Café crème")
+	}
+}
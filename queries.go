@@ -12,8 +12,106 @@ var queries = struct {
 	EntryClosureTableCreate              string //Create all closure table entries for the new entry
 	VoteUpsert                           string //Upsert a vote
 	FindVote                             string //Retrieve a vote by userId and entryId
+	VoteForUpdate                        string //Lock a vote by entryId and userId and retrieve it
+	FindVotes                            string //Retrieve a user's votes on a set of entries
+	VoteEventCreate                      string //Append a change of vote to the vote event log
+	VoteEventsRecent                     string //Number of vote events by a user within the last $2 seconds
+	VoteEvents                           string //The history of one user's vote on an entry
+	VoteEventPairs                       string //Pairs of users who cast the same vote on an entry within $2 seconds of each other, during the last $1 seconds
+	KarmaAdjust                          string //Add $2 points to the karma that the author of entry $1 has in its forum
+	KarmaDeleteAll                       string //Forget all karma, before recomputing it
+	KarmaRecompute                       string //Compute all karma from the vote counters of every entry
+	KarmaOfUser                          string //A user's karma across all forums
+	KarmaLeaderboard                     string //Users with the most karma within a forum and its sub-forums
+	KarmaShiftSubtree                    string //Add $2 times the points of entry $1 and its descendants to their authors' karma
+	EntriesByAuthor                      string //A user's posts and comments, newest first, with their thread and parent
+	NotificationCreate                   string //Notify a user of an entry
+	Inbox                                string //A user's notifications, newest first
+	NotificationsUnread                  string //Count a user's unread notifications
+	NotificationsMarkRead                string //Mark some or all of a user's notifications as read
+	SubtreeNotificationsDelete           string //Remove the notifications caused by a set of entries
+	MentionsCreate                       string //Resolve handles to accounts and record them as mentioned by an entry
+	MentionsForEntries                   string //The accounts mentioned by a set of entries
+	SubtreeMentionsDelete                string //Remove the mentions made by a set of entries
+	SubscriptionUpsert                   string //Follow or mute an entry
+	SubscriptionDelete                   string //Stop following or muting an entry
+	Subscriptions                        string //Everything a user follows or has muted
+	SubscriptionNotificationsCreate      string //Notify the users following an ancestor of an entry, less some exceptions, unless their nearest subscription is muted
+	SubtreeSubscriptionsDelete           string //Remove the subscriptions to a set of entries
+	VisitUpsert                          string //Record that a user has seen an entry and everything beneath it
+	NewCounts                            string //Number of descendants of each of a set of entries created since a user's last visit
+	SubtreeVisitsDelete                  string //Remove the visits to a set of entries
+	Search                               string //Posts and comments matching a full-text query, most relevant first
+	AncestorIds                          string //The IDs of an entry's ancestors, nearest first
+	OutboxCreate                         string //Write an event to the outbox
+	OutboxDelivered                      string //Mark an event in the outbox as delivered
+	OutboxPending                        string //The oldest undelivered events, locked against concurrent delivery
+	Notify                               string //Send a payload to every session listening on a channel
+	NewestComments                       string //The newest entries anywhere beneath an entry, with their parents
+	SubtreeVoteEventsDelete              string //Remove the vote history of a set of entries
+	SubtreeDeltasDelete                  string //Remove the edit history of a set of entries
+	SubtreeReportsDelete                 string //Remove the reports against a set of entries
+	SubtreeMergesDelete                  string //Remove the records of merges into or out of a set of entries
+	SubtreeOutboxDelete                  string //Remove the events about a set of entries
+	SubtreeModLogRedact                  string //Blank out what the moderation log quoted from a set of entries
+	LastEdits                            string //The time of the latest edit of each of a set of entries
+	EntryShadowed                        string //Whether an entry's author is shadow-banned from a forum above it
+	MentionsForEntryDelete               string //Remove the mentions made by one entry, returning who was mentioned
+	MentionNotificationsCreate           string //Notify a set of users, less some exceptions, that an entry mentions them
+	SubtreeBansDelete                    string //Remove the bans from a set of forums
+	SubtreeModeratorsDelete              string //Remove the moderators of a set of forums
+	SubtreeKarmaDelete                   string //Remove the karma earned within a set of forums
+	VoteCountsAdjust                     string //Add to the upvote and downvote counters of an entry
+	VoteCountsReset                      string //Zero the upvote and downvote counters of an entry
+	VoteCountsRepair                     string //Recompute the counters of the given entries (or all, if NULL) from the vote table
+	VoteDelete                           string //Retract a vote by entryId and userId
+	PreviewUpsert                        string //Create or replace the link preview of an entry
+	FindPreview                          string //Retrieve the link preview of an entry
+	TopForums                            string //Forums that have no parent
+	SubForums                            string //Forums that are immediate descendants of an entry
+	ForumPosts                           string //Non-forum immediate descendants of a forum with their comment counts, sorted and paged
+	EntrySoftDelete                      string //Blank out an entry while leaving it in its thread
+	SubtreeIds                           string //IDs of an entry and all of its descendants
+	SubtreeVotesDelete                   string //Remove the votes cast on a set of entries
+	SubtreePreviewsDelete                string //Remove the link previews of a set of entries
+	SubtreeClosuresDelete                string //Remove every closure row that points to a set of entries
+	SubtreeEntriesDelete                 string //Remove a set of entries
+	TakedownCreate                       string //Record that a subtree was permanently removed
+	EntryExists                          string //Whether an entry exists
+	IsDescendant                         string //Whether $2 is $1 or one of its descendants
+	SubtreeDisconnect                    string //Remove the closure rows linking a subtree to the ancestors of its root
+	SubtreeConnect                       string //Link a subtree to a new parent and all of that parent's ancestors
+	ChildIds                             string //IDs of the immediate descendants of an entry
+	VotesTransfer                        string //Copy votes from one entry to another, unless the voter already voted on the destination, returning the copies
+	VotesForEntryDelete                  string //Remove every vote cast on one entry, returning the votes
+	EntryRedirect                        string //Turn an entry into a stub pointing at another entry
+	MergeCreate                          string //Record that one thread was merged into another
+	ThreadState                          string //Lock state, kind and age of an entry and each of its ancestors, nearest first
+	EntryLock                            string //Lock or unlock an entry
+	EntryPin                             string //Pin or unpin an entry
+	ForumOf                              string //The nearest forum among an entry and its ancestors
+	ReportCreate                         string //File a report against an entry, unless the reporter has an open report on it already
+	ReportQueue                          string //Entries with open reports within a forum and its sub-forums, with report counts
+	EntryReports                         string //Every report filed against one entry
+	ReportsResolve                       string //Close all open reports against an entry
+	BanCreate                            string //Ban a user from a forum
+	ModLogCreate                         string //Append a moderation action to the audit log
+	ModLogByForum                        string //Moderation actions within a forum and its sub-forums, newest first
+	ModLogByActor                        string //Moderation actions taken by one moderator, newest first
+	EntryForUpdate                       string //Lock an entry's row and retrieve what a moderation action may change
+	ParentOf                             string //The immediate parent of an entry
+	EntryEdit                            string //Replace the title and body of an entry
+	DeltaCreate                          string //Record the previous version of an edited entry
+	VoteTotals                           string //Upvotes and downvotes cast on one entry
+	EntryState                           string //Retrieve what permission checks need to know about an entry
+	IsModerator                          string //Whether a user moderates a forum among an entry and its ancestors
+	ModeratorCreate                      string //Appoint a user to moderate a forum, unless they already do
+	ModeratorDelete                      string //Dismiss a user as moderator of a forum
+	ActiveBan                            string //The ban, if any, keeping a user from a forum above an entry; real bans before shadow bans
+	BansLift                             string //Lift every ban of a user from one forum
+	ForumBans                            string //Active bans from a forum
 }{
-	DescendantEntriesChildParent: `select ancestor, e.id, e.title, e.body, e.url, e.created, e.author_id, e.forum, a.handle, extract(epoch from (now()-e.created)) seconds, COALESCE(v.upvotes, 0) upvotes, COALESCE(v.downvotes, 0) downvotes, COALESCE(vu.upvote::int,0) uupvote, COALESCE(vu.downvote::int,0) udownvote 
+	DescendantEntriesChildParent: `select ancestor, e.id, e.title, e.body, e.url, e.created, CASE WHEN e.deleted THEN 0 ELSE e.author_id END author_id, e.forum, e.deleted, e.locked, e.pinned, CASE WHEN e.deleted THEN '[deleted]' ELSE a.handle END handle, extract(epoch from (now()-e.created)) seconds, e.upvotes, e.downvotes, COALESCE(vu.upvote::int,0) uupvote, COALESCE(vu.downvote::int,0) udownvote, COALESCE(e.created>visit.seen AND e.author_id<>$2, false) is_new
 from entry e
 join entry_closures ec ON (
 	e.id=ec.descendant
@@ -44,16 +142,21 @@ join entry_closures ec ON (
 	)
 )
 join account a ON a.id=e.author_id
-left join (
-	select entry_id, SUM(upvote::int) upvotes, SUM(downvote::int) downvotes 
-	from vote
-	group by entry_id
-) v ON v.entry_id=e.id
 left join vote vu on (
 	vu.entry_id=e.id
 	AND vu.user_id=$2
-)`,
-	AncestorEntriesChildParent: `select descendant, e.id, e.title, e.body, e.url, e.created, e.author_id, e.forum, a.handle, extract(epoch from (now()-e.created)) seconds, COALESCE(v.upvotes, 0) upvotes, COALESCE(v.downvotes, 0) downvotes, COALESCE(vu.upvote::int,0) uupvote, COALESCE(vu.downvote::int,0) udownvote 
+)
+left join lateral (
+	-- The viewer's latest visit to the entry or any of its ancestors
+	select max(tv.seen) seen
+	from thread_visit tv
+	join entry_closures vc ON vc.ancestor=tv.entry_id
+	where vc.descendant=e.id
+	AND tv.user_id=$2
+) visit on true
+-- Shadow-banned authors still see their own entries
+where (e.author_id=$2 OR NOT ` + shadowBanned("e") + `)`,
+	AncestorEntriesChildParent: `select descendant, e.id, e.title, e.body, e.url, e.created, CASE WHEN e.deleted THEN 0 ELSE e.author_id END author_id, e.forum, e.deleted, e.locked, e.pinned, CASE WHEN e.deleted THEN '[deleted]' ELSE a.handle END handle, extract(epoch from (now()-e.created)) seconds, e.upvotes, e.downvotes, COALESCE(vu.upvote::int,0) uupvote, COALESCE(vu.downvote::int,0) udownvote, COALESCE(e.created>visit.seen AND e.author_id<>$2, false) is_new
 from entry e
 join entry_closures ec ON (
 	e.id=ec.ancestor
@@ -84,43 +187,50 @@ join entry_closures ec ON (
 	)
 )
 join account a ON a.id=e.author_id
-left join (
-	select entry_id, SUM(upvote::int) upvotes, SUM(downvote::int) downvotes 
-	from vote
-	group by entry_id
-) v ON v.entry_id=e.id
 left join vote vu on (
 	vu.entry_id=e.id
 	AND vu.user_id=$2
-)`,
-	DepthOneDescendantEntriesChildParent: `select ancestor, e.id, e.title, e.body, e.url, e.created, e.author_id, e.forum, a.handle, extract(epoch from (now()-e.created)) seconds, COALESCE(v.upvotes, 0) upvotes, COALESCE(v.downvotes, 0) downvotes, COALESCE(vu.upvote::int,0) uupvote, COALESCE(vu.downvote::int,0) udownvote 
+)
+left join lateral (
+	-- The viewer's latest visit to the entry or any of its ancestors
+	select max(tv.seen) seen
+	from thread_visit tv
+	join entry_closures vc ON vc.ancestor=tv.entry_id
+	where vc.descendant=e.id
+	AND tv.user_id=$2
+) visit on true
+-- Shadow-banned authors still see their own entries
+where (e.author_id=$2 OR NOT ` + shadowBanned("e") + `)`,
+	DepthOneDescendantEntriesChildParent: `select ancestor, e.id, e.title, e.body, e.url, e.created, CASE WHEN e.deleted THEN 0 ELSE e.author_id END author_id, e.forum, e.deleted, e.locked, e.pinned, CASE WHEN e.deleted THEN '[deleted]' ELSE a.handle END handle, extract(epoch from (now()-e.created)) seconds, e.upvotes, e.downvotes, COALESCE(vu.upvote::int,0) uupvote, COALESCE(vu.downvote::int,0) udownvote, COALESCE(e.created>visit.seen AND e.author_id<>$2, false) is_new
 from entry_closures closure
 join entry e ON e.id = closure.descendant
 join account a ON a.id=e.author_id
-left join (
-	select entry_id, SUM(upvote::int) upvotes, SUM(downvote::int) downvotes 
-	from vote
-	group by entry_id
-) v ON v.entry_id=e.id
 left join vote vu on (
 	vu.entry_id=e.id
 	AND vu.user_id=$2
 )
+left join lateral (
+	-- The viewer's latest visit to the entry or any of its ancestors
+	select max(tv.seen) seen
+	from thread_visit tv
+	join entry_closures vc ON vc.ancestor=tv.entry_id
+	where vc.descendant=e.id
+	AND tv.user_id=$2
+) visit on true
 where 1=1
 AND closure.ancestor = $1
-AND (closure.depth=1 OR closure.depth=0)`,
-	OneEntry: `SELECT e.id, e.title, e.body, e.url, e.created, e.author_id, e.forum, a.handle, extract(epoch from (now()-e.created)) seconds, COALESCE(v.upvotes, 0), COALESCE(v.downvotes, 0)
+AND (closure.depth=1 OR closure.depth=0)
+-- Shadow-banned authors still see their own entries
+AND (e.author_id=$2 OR NOT ` + shadowBanned("e") + `)`,
+	OneEntry: `SELECT e.id, e.title, e.body, e.url, e.created, CASE WHEN e.deleted THEN 0 ELSE e.author_id END author_id, e.forum, e.deleted, e.locked, e.pinned, CASE WHEN e.deleted THEN '[deleted]' ELSE a.handle END handle, extract(epoch from (now()-e.created)) seconds, e.upvotes, e.downvotes, COALESCE(e.redirect_id, 0)
 FROM entry e
 JOIN account a ON a.id=e.author_id
-LEFT JOIN (
-	SELECT entry_id, SUM(upvote::int) upvotes, SUM(downvote::int) downvotes 
-	FROM vote
-	GROUP BY entry_id
-) v ON v.entry_id=e.id
 WHERE 1=1
 AND e.id=$1
+-- Shadow-banned authors still see their own entries
+AND (e.author_id=$2 OR NOT ` + shadowBanned("e") + `)
 `,
-	EntryCreate: `INSERT INTO entry (title, body, url, author_id) VALUES ($1, $2, $3, $4) RETURNING id`,
+	EntryCreate: `INSERT INTO entry (title, body, url, author_id, forum) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
 	EntryClosureTableCreate: `INSERT INTO entry_closures
 	select cast($1 as bigint) newancestor, cast($1 as bigint) newdescendant, 0 newdepth
 	union 
@@ -148,4 +258,530 @@ WHERE NOT EXISTS (SELECT 1
 	FROM upsert up 
 	WHERE up.user_id = new_values.user_id AND up.entry_id = new_values.entry_id)`,
 	FindVote: `SELECT entry_id, user_id, upvote, downvote, created FROM vote WHERE entry_id=$1 and user_id=$2`,
+	VoteDelete: `DELETE FROM vote WHERE entry_id=$1 AND user_id=$2`,
+	VoteForUpdate:    `SELECT upvote, downvote FROM vote WHERE entry_id=$1 AND user_id=$2 FOR UPDATE`,
+	FindVotes:        `SELECT entry_id, user_id, upvote, downvote, created FROM vote WHERE user_id=$1 AND entry_id = ANY($2::bigint[])`,
+	VoteEventCreate:  `INSERT INTO vote_event (entry_id, user_id, value, previous) VALUES ($1, $2, $3, $4)`,
+	VoteEventsRecent: `SELECT COUNT(*) FROM vote_event WHERE user_id=$1 AND created > now() - $2 * interval '1 second'`,
+	VoteEvents:       `SELECT id, entry_id, user_id, value, previous, created FROM vote_event WHERE entry_id=$1 AND user_id=$2 ORDER BY created ASC, id ASC`,
+	VoteEventPairs: `SELECT DISTINCT a.user_id, b.user_id, a.entry_id
+FROM vote_event a
+JOIN vote_event b ON (
+	b.entry_id=a.entry_id
+	AND b.user_id>a.user_id
+	AND b.value=a.value
+	AND abs(extract(epoch from (b.created-a.created))) <= $2
+)
+WHERE a.value<>0
+AND a.created > now() - $1 * interval '1 second'
+AND b.created > now() - $1 * interval '1 second'`,
+	VoteCountsAdjust: `UPDATE entry SET upvotes=upvotes+$2, downvotes=downvotes+$3 WHERE id=$1`,
+	VoteCountsReset:  `UPDATE entry SET upvotes=0, downvotes=0 WHERE id=$1`,
+	VoteCountsRepair: `UPDATE entry e
+SET upvotes=t.upvotes, downvotes=t.downvotes
+FROM (
+	SELECT x.id, COALESCE(SUM(v.upvote::int), 0) upvotes, COALESCE(SUM(v.downvote::int), 0) downvotes
+	FROM entry x
+	LEFT JOIN vote v ON v.entry_id=x.id
+	WHERE ($1::bigint[] IS NULL OR x.id = ANY($1::bigint[]))
+	GROUP BY x.id
+) t
+WHERE e.id=t.id
+AND (e.upvotes<>t.upvotes OR e.downvotes<>t.downvotes)`,
+	PreviewUpsert: `WITH new_values (entry_id, url, title, description, image, canonical, fetched) as (
+  values 
+     ($1::bigint, $2::text, $3::text, $4::text, $5::text, $6::text, $7::timestamptz)
+),
+upsert as
+( 
+    update entry_preview m 
+        set url = nv.url, title = nv.title, description = nv.description, image = nv.image, canonical = nv.canonical, fetched = nv.fetched
+    FROM new_values nv
+    WHERE m.entry_id = nv.entry_id
+    RETURNING m.*
+)
+INSERT INTO entry_preview (entry_id, url, title, description, image, canonical, fetched)
+SELECT entry_id, url, title, description, image, canonical, fetched
+FROM new_values
+WHERE NOT EXISTS (SELECT 1 
+	FROM upsert up 
+	WHERE up.entry_id = new_values.entry_id)`,
+	FindPreview: `SELECT entry_id, url, title, description, image, canonical, fetched FROM entry_preview WHERE entry_id=$1`,
+	TopForums: `SELECT e.id, e.title, e.body, e.url, e.created, CASE WHEN e.deleted THEN 0 ELSE e.author_id END author_id, e.forum, e.deleted, e.locked, e.pinned, CASE WHEN e.deleted THEN '[deleted]' ELSE a.handle END handle, extract(epoch from (now()-e.created)) seconds
+FROM entry e
+JOIN account a ON a.id=e.author_id
+WHERE 1=1
+AND e.forum
+AND NOT EXISTS (
+	SELECT 1
+	FROM entry_closures ec
+	WHERE ec.descendant=e.id
+	AND ec.depth>0
+)
+ORDER BY e.title ASC`,
+	SubForums: `SELECT e.id, e.title, e.body, e.url, e.created, CASE WHEN e.deleted THEN 0 ELSE e.author_id END author_id, e.forum, e.deleted, e.locked, e.pinned, CASE WHEN e.deleted THEN '[deleted]' ELSE a.handle END handle, extract(epoch from (now()-e.created)) seconds
+FROM entry_closures closure
+JOIN entry e ON e.id=closure.descendant
+JOIN account a ON a.id=e.author_id
+WHERE 1=1
+AND e.forum
+AND closure.ancestor=$1
+AND closure.depth=1
+ORDER BY e.title ASC`,
+	ForumPosts: `SELECT e.id, e.title, e.body, e.url, e.created, CASE WHEN e.deleted THEN 0 ELSE e.author_id END author_id, e.forum, e.deleted, e.locked, e.pinned, CASE WHEN e.deleted THEN '[deleted]' ELSE a.handle END handle, extract(epoch from (now()-e.created)) seconds, e.upvotes, e.downvotes, c.comments
+FROM entry_closures closure
+JOIN entry e ON e.id=closure.descendant
+JOIN account a ON a.id=e.author_id
+JOIN LATERAL (
+	-- Every descendant of the post that the viewer can see is a comment in its thread
+	SELECT COUNT(*) comments
+	FROM entry_closures dc
+	JOIN entry d ON d.id=dc.descendant
+	WHERE dc.ancestor=e.id
+	AND dc.depth>0
+	AND NOT d.deleted
+	AND (d.author_id=$5 OR NOT ` + shadowBanned("d") + `)
+) c ON true
+WHERE 1=1
+AND NOT e.forum
+-- Shadow-banned authors still see their own posts
+AND (e.author_id=$5 OR NOT ` + shadowBanned("e") + `)
+AND closure.ancestor=$1
+AND closure.depth=1
+ORDER BY e.pinned DESC, CASE $2::text
+		WHEN 'top' THEN (e.upvotes-e.downvotes)::float8
+		-- Mirrors Entry.score() for an entry whose children are not loaded
+		WHEN 'hot' THEN ((e.upvotes-e.downvotes) + 1e-3) / power(extract(epoch from (now()-e.created))/(60*60) + 2, 1.8)
+		ELSE 0
+	END DESC, e.created DESC, e.id DESC
+LIMIT $3 OFFSET $4`,
+	EntrySoftDelete: `UPDATE entry 
+SET body=$2, deleted=true, deleted_by=$3, deleted_reason=$4, deleted_at=now() 
+WHERE id=$1`,
+	SubtreeIds:            `SELECT descendant FROM entry_closures WHERE ancestor=$1`,
+	SubtreeVotesDelete:    `DELETE FROM vote WHERE entry_id = ANY($1::bigint[])`,
+	SubtreePreviewsDelete: `DELETE FROM entry_preview WHERE entry_id = ANY($1::bigint[])`,
+	SubtreeClosuresDelete: `DELETE FROM entry_closures WHERE descendant = ANY($1::bigint[])`,
+	SubtreeEntriesDelete:  `DELETE FROM entry WHERE id = ANY($1::bigint[])`,
+	TakedownCreate:        `INSERT INTO entry_takedown (entry_id, entry_count, deleted_by, reason) VALUES ($1, $2, $3, $4)`,
+	EntryExists:  `SELECT EXISTS (SELECT 1 FROM entry WHERE id=$1)`,
+	IsDescendant: `SELECT EXISTS (SELECT 1 FROM entry_closures WHERE ancestor=$1 AND descendant=$2)`,
+	SubtreeDisconnect: `DELETE FROM entry_closures
+WHERE descendant IN (
+	-- Every member of the subtree, including its root
+	SELECT descendant
+	FROM entry_closures
+	WHERE ancestor=$1
+)
+AND ancestor IN (
+	-- Every proper ancestor of the subtree's root
+	SELECT ancestor
+	FROM entry_closures
+	WHERE descendant=$1
+	AND ancestor<>descendant
+)`,
+	SubtreeConnect: `INSERT INTO entry_closures (ancestor, descendant, depth)
+SELECT super.ancestor, sub.descendant, super.depth+sub.depth+1
+FROM entry_closures super
+CROSS JOIN entry_closures sub
+WHERE super.descendant=$2
+AND sub.ancestor=$1`,
+	ChildIds: `SELECT descendant FROM entry_closures WHERE ancestor=$1 AND depth=1`,
+	VotesTransfer: `INSERT INTO vote (user_id, entry_id, upvote, downvote, created)
+SELECT s.user_id, $2, s.upvote, s.downvote, s.created
+FROM vote s
+WHERE s.entry_id=$1
+AND NOT EXISTS (
+	SELECT 1
+	FROM vote t
+	WHERE t.entry_id=$2
+	AND t.user_id=s.user_id
+)
+RETURNING user_id, upvote, downvote`,
+	VotesForEntryDelete: `DELETE FROM vote WHERE entry_id=$1 RETURNING user_id, upvote, downvote`,
+	EntryRedirect:       `UPDATE entry SET redirect_id=$2 WHERE id=$1`,
+	MergeCreate:         `INSERT INTO entry_merge (source_id, target_id, merged_by, votes_moved) VALUES ($1, $2, $3, $4)`,
+	ThreadState: `SELECT e.id, e.locked, e.forum, e.created
+FROM entry_closures ec
+JOIN entry e ON e.id=ec.ancestor
+WHERE ec.descendant=$1
+ORDER BY ec.depth ASC`,
+	EntryLock: `UPDATE entry SET locked=$2 WHERE id=$1`,
+	EntryPin:  `UPDATE entry SET pinned=$2 WHERE id=$1`,
+	ForumOf: `SELECT ec.ancestor
+FROM entry_closures ec
+JOIN entry e ON e.id=ec.ancestor
+WHERE ec.descendant=$1
+AND e.forum
+ORDER BY ec.depth ASC
+LIMIT 1`,
+	ReportCreate: `INSERT INTO report (entry_id, forum_id, reporter_id, reason)
+SELECT $1, $2, $3, $4
+WHERE NOT EXISTS (
+	SELECT 1
+	FROM report
+	WHERE entry_id=$1
+	AND reporter_id=$3
+	AND resolved_at IS NULL
+)`,
+	ReportQueue: `SELECT e.id, e.title, e.body, e.url, e.created, e.author_id, e.forum, e.deleted, e.locked, e.pinned, a.handle, extract(epoch from (now()-e.created)) seconds, r.forum_id, r.reports, r.first_reported, r.last_reported
+FROM (
+	SELECT entry_id, MIN(forum_id) forum_id, COUNT(*) reports, MIN(created) first_reported, MAX(created) last_reported
+	FROM report
+	WHERE resolved_at IS NULL
+	AND forum_id IN (
+		-- The forum and all of its sub-forums
+		SELECT descendant
+		FROM entry_closures
+		WHERE ancestor=$1
+	)
+	GROUP BY entry_id
+) r
+JOIN entry e ON e.id=r.entry_id
+JOIN account a ON a.id=e.author_id
+ORDER BY r.reports DESC, r.last_reported DESC
+LIMIT $2 OFFSET $3`,
+	EntryReports: `SELECT r.id, r.entry_id, r.forum_id, r.reporter_id, a.handle, r.reason, r.created, COALESCE(r.resolution, ''), COALESCE(r.resolved_by, 0), COALESCE(r.note, ''), r.resolved_at
+FROM report r
+JOIN account a ON a.id=r.reporter_id
+WHERE r.entry_id=$1
+ORDER BY r.created DESC`,
+	ReportsResolve: `UPDATE report
+SET resolution=$2, resolved_by=$3, note=$4, resolved_at=now()
+WHERE entry_id=$1
+AND resolved_at IS NULL`,
+	BanCreate: `INSERT INTO ban (forum_id, user_id, banned_by, expires, shadow) VALUES ($1, $2, $3, $4, $5)`,
+	ModLogCreate: `INSERT INTO mod_log (forum_id, actor_id, entry_id, action, before, after) VALUES ($1, $2, $3, $4, $5, $6)`,
+	ModLogByForum: `SELECT l.id, l.forum_id, l.actor_id, a.handle, l.entry_id, l.action, l.before, l.after, l.created
+FROM mod_log l
+JOIN account a ON a.id=l.actor_id
+WHERE l.forum_id IN (
+	-- The forum and all of its sub-forums
+	SELECT descendant
+	FROM entry_closures
+	WHERE ancestor=$1
+)
+ORDER BY l.created DESC, l.id DESC
+LIMIT $2 OFFSET $3`,
+	ModLogByActor: `SELECT l.id, l.forum_id, l.actor_id, a.handle, l.entry_id, l.action, l.before, l.after, l.created
+FROM mod_log l
+JOIN account a ON a.id=l.actor_id
+WHERE l.actor_id=$1
+ORDER BY l.created DESC, l.id DESC
+LIMIT $2 OFFSET $3`,
+	EntryForUpdate: `SELECT author_id, title, body, forum, deleted, locked, pinned FROM entry WHERE id=$1 FOR UPDATE`,
+	EntryState:     `SELECT author_id, title, body, forum, deleted, locked, pinned FROM entry WHERE id=$1`,
+	ParentOf:       `SELECT ancestor FROM entry_closures WHERE descendant=$1 AND depth=1`,
+	EntryEdit:      `UPDATE entry SET title=$2, body=$3 WHERE id=$1`,
+	DeltaCreate:    `INSERT INTO entry_delta (post_id, title_delta, body_delta, modifier_id) VALUES ($1, $2, $3, $4) RETURNING id, modified`,
+	VoteTotals:     `SELECT upvotes, downvotes FROM entry WHERE id=$1`,
+	IsModerator: `SELECT EXISTS (
+	SELECT 1
+	FROM moderator m
+	JOIN entry_closures ec ON ec.ancestor=m.forum_id
+	WHERE ec.descendant=$1
+	AND m.user_id=$2
+)`,
+	ModeratorCreate: `INSERT INTO moderator (forum_id, user_id, appointed_by)
+SELECT $1, $2, $3
+WHERE NOT EXISTS (SELECT 1 FROM moderator WHERE forum_id=$1 AND user_id=$2)`,
+	ModeratorDelete: `DELETE FROM moderator WHERE forum_id=$1 AND user_id=$2`,
+	ActiveBan: `SELECT b.forum_id, b.expires, b.shadow
+FROM ban b
+JOIN entry_closures ec ON ec.ancestor=b.forum_id
+WHERE ec.descendant=$1
+AND b.user_id=$2
+AND (b.expires IS NULL OR b.expires>now())
+ORDER BY b.shadow ASC, b.expires DESC NULLS FIRST
+LIMIT 1`,
+	BansLift: `DELETE FROM ban WHERE forum_id=$1 AND user_id=$2`,
+	ForumBans: `SELECT b.forum_id, b.user_id, a.handle, b.banned_by, b.created, b.expires, b.shadow
+FROM ban b
+JOIN account a ON a.id=b.user_id
+WHERE b.forum_id=$1
+AND (b.expires IS NULL OR b.expires>now())
+ORDER BY b.created DESC`,
+	KarmaAdjust:    karmaUpsert(karmaEntries("$2::bigint", "entry e", "e.id=$1")),
+	KarmaDeleteAll: `DELETE FROM karma`,
+	KarmaRecompute: karmaUpsert(karmaEntries("e.upvotes-e.downvotes", "entry e", "true")),
+	KarmaOfUser: `SELECT a.id, a.handle, COALESCE(SUM(k.post_points), 0), COALESCE(SUM(k.comment_points), 0)
+FROM account a
+LEFT JOIN karma k ON k.user_id=a.id
+WHERE a.id=$1
+GROUP BY a.id, a.handle`,
+	KarmaLeaderboard: `SELECT a.id, a.handle, SUM(k.post_points) post_points, SUM(k.comment_points) comment_points
+FROM karma k
+JOIN account a ON a.id=k.user_id
+WHERE k.forum_id IN (
+	-- The forum and all of its sub-forums
+	SELECT descendant
+	FROM entry_closures
+	WHERE ancestor=$1
+)
+GROUP BY a.id, a.handle
+ORDER BY SUM(k.post_points)+SUM(k.comment_points) DESC, a.id ASC
+LIMIT $2`,
+	EntriesByAuthor: `SELECT e.id, e.title, e.body, e.url, e.created, e.author_id, e.forum, e.deleted, e.locked, e.pinned, a.handle, extract(epoch from (now()-e.created)) seconds, e.upvotes, e.downvotes, COALESCE(vu.upvote::int,0) uupvote, COALESCE(vu.downvote::int,0) udownvote,
+	root.id, root.title, COALESCE(parent.id, 0), COALESCE(CASE WHEN parent.forum THEN parent.title WHEN parent.deleted OR parent.hidden THEN '[deleted]' ELSE left(parent.body, $5) END, '')
+FROM entry e
+JOIN account a ON a.id=e.author_id
+JOIN LATERAL (
+	-- The thread's post: the furthest ancestor (or the entry itself) that is not a forum
+	SELECT r.id, r.title
+	FROM entry_closures rc
+	JOIN entry r ON r.id=rc.ancestor
+	WHERE rc.descendant=e.id
+	AND NOT r.forum
+	ORDER BY rc.depth DESC
+	LIMIT 1
+) root ON true
+LEFT JOIN LATERAL (
+	-- The parent's text is kept from viewers it is hidden from, like a deleted one's
+	SELECT p.id, p.title, p.body, p.forum, p.deleted, (p.author_id<>$2 AND ` + shadowBanned("p") + `) hidden
+	FROM entry_closures pc
+	JOIN entry p ON p.id=pc.ancestor
+	WHERE pc.descendant=e.id
+	AND pc.depth=1
+) parent ON true
+LEFT JOIN vote vu ON (
+	vu.entry_id=e.id
+	AND vu.user_id=$2
+)
+WHERE 1=1
+AND e.author_id=$1
+AND NOT e.forum
+AND NOT e.deleted
+-- Shadow-banned authors still see their own entries
+AND (e.author_id=$2 OR NOT ` + shadowBanned("e") + `)
+ORDER BY e.created DESC, e.id DESC
+LIMIT $3 OFFSET $4`,
+	NotificationCreate: `INSERT INTO notification (user_id, entry_id, actor_id, kind) VALUES ($1, $2, $3, $4)`,
+	Inbox: `SELECT n.id, n.user_id, n.entry_id, n.actor_id, a.handle, n.kind, n.created, n.read, e.title, left(e.body, $5)
+FROM notification n
+JOIN entry e ON e.id=n.entry_id
+JOIN account a ON a.id=n.actor_id
+WHERE 1=1
+AND n.user_id=$1
+AND (NOT $2::boolean OR NOT n.read)
+AND NOT e.deleted
+ORDER BY n.created DESC, n.id DESC
+LIMIT $3 OFFSET $4`,
+	NotificationsUnread: `SELECT count(*)
+FROM notification n
+JOIN entry e ON e.id=n.entry_id
+WHERE n.user_id=$1
+AND NOT n.read
+AND NOT e.deleted`,
+	// A NULL array marks every notification
+	NotificationsMarkRead: `UPDATE notification
+SET read=true
+WHERE user_id=$1
+AND NOT read
+AND ($2::bigint[] IS NULL OR id = ANY($2::bigint[]))`,
+	SubtreeNotificationsDelete: `DELETE FROM notification WHERE entry_id = ANY($1::bigint[])`,
+	MentionsCreate: `WITH m AS (
+	INSERT INTO mention (entry_id, user_id)
+	SELECT $1, a.id
+	FROM account a
+	WHERE a.handle = ANY($2::text[])
+	ON CONFLICT DO NOTHING
+	RETURNING user_id
+)
+SELECT m.user_id, a.handle
+FROM m
+JOIN account a ON a.id=m.user_id
+ORDER BY a.handle`,
+	MentionsForEntries: `SELECT m.entry_id, m.user_id, a.handle
+FROM mention m
+JOIN account a ON a.id=m.user_id
+WHERE m.entry_id = ANY($1::bigint[])
+ORDER BY m.entry_id, a.handle`,
+	SubtreeMentionsDelete: `DELETE FROM mention WHERE entry_id = ANY($1::bigint[])`,
+	SubscriptionUpsert: `INSERT INTO subscription (user_id, entry_id, muted) VALUES ($1, $2, $3)
+ON CONFLICT (user_id, entry_id) DO UPDATE SET muted=EXCLUDED.muted`,
+	SubscriptionDelete: `DELETE FROM subscription WHERE user_id=$1 AND entry_id=$2`,
+	Subscriptions: `SELECT s.user_id, s.entry_id, s.muted, s.created, e.title
+FROM subscription s
+JOIN entry e ON e.id=s.entry_id
+WHERE s.user_id=$1
+ORDER BY s.created DESC`,
+	// For each user, only the subscription on the nearest ancestor counts
+	SubscriptionNotificationsCreate: `INSERT INTO notification (user_id, entry_id, actor_id, kind)
+SELECT user_id, $1, $2, $3
+FROM (
+	SELECT DISTINCT ON (s.user_id) s.user_id, s.muted
+	FROM subscription s
+	JOIN entry_closures c ON c.ancestor=s.entry_id
+	WHERE c.descendant=$1
+	AND c.depth>0
+	ORDER BY s.user_id, c.depth ASC
+) nearest
+WHERE NOT muted
+AND user_id <> ALL($4::bigint[])`,
+	SubtreeSubscriptionsDelete: `DELETE FROM subscription WHERE entry_id = ANY($1::bigint[])`,
+	VisitUpsert: `INSERT INTO thread_visit (user_id, entry_id, seen) VALUES ($1, $2, now())
+ON CONFLICT (user_id, entry_id) DO UPDATE SET seen=EXCLUDED.seen`,
+	NewCounts: `SELECT p.id, COUNT(d.id)
+FROM unnest($2::bigint[]) p(id)
+JOIN LATERAL (
+	-- The user's latest visit to the entry or any of its ancestors
+	SELECT max(tv.seen) seen
+	FROM thread_visit tv
+	JOIN entry_closures vc ON vc.ancestor=tv.entry_id
+	WHERE vc.descendant=p.id
+	AND tv.user_id=$1
+) visit ON true
+LEFT JOIN entry_closures dc ON (
+	dc.ancestor=p.id
+	AND dc.depth>0
+)
+LEFT JOIN entry d ON (
+	d.id=dc.descendant
+	AND d.created>visit.seen
+	AND d.author_id<>$1
+	AND NOT d.deleted
+	AND NOT ` + shadowBanned("d") + `
+)
+GROUP BY p.id`,
+	SubtreeVisitsDelete: `DELETE FROM thread_visit WHERE entry_id = ANY($1::bigint[])`,
+	Search: `WITH q AS (
+	SELECT websearch_to_tsquery('english', $1) query
+)
+SELECT e.id, e.title, e.body, e.url, e.created, e.author_id, e.forum, e.deleted, e.locked, e.pinned, a.handle, extract(epoch from (now()-e.created)) seconds, e.upvotes, e.downvotes, COALESCE(vu.upvote::int,0) uupvote, COALESCE(vu.downvote::int,0) udownvote,
+	root.id, root.title, ts_rank(to_tsvector('english', e.title || ' ' || e.body), q.query) rank, ts_headline('english', e.body, q.query, $6)
+FROM entry e
+CROSS JOIN q
+JOIN account a ON a.id=e.author_id
+JOIN LATERAL (
+	-- The thread's post: the furthest ancestor (or the entry itself) that is not a forum
+	SELECT r.id, r.title
+	FROM entry_closures rc
+	JOIN entry r ON r.id=rc.ancestor
+	WHERE rc.descendant=e.id
+	AND NOT r.forum
+	ORDER BY rc.depth DESC
+	LIMIT 1
+) root ON true
+LEFT JOIN vote vu ON (
+	vu.entry_id=e.id
+	AND vu.user_id=$3
+)
+WHERE 1=1
+AND to_tsvector('english', e.title || ' ' || e.body) @@ q.query
+AND NOT e.forum
+AND NOT e.deleted
+AND ($2=0 OR EXISTS (
+	select 1
+	from entry_closures sc
+	where sc.ancestor=$2
+	AND sc.descendant=e.id
+))
+-- Shadow-banned authors still see their own entries
+AND (e.author_id=$3 OR NOT ` + shadowBanned("e") + `)
+ORDER BY rank DESC, e.created DESC, e.id DESC
+LIMIT $4 OFFSET $5`,
+	AncestorIds:     `SELECT ancestor FROM entry_closures WHERE descendant=$1 AND depth>0 ORDER BY depth`,
+	OutboxCreate:    `INSERT INTO outbox (kind, entry_id, payload) VALUES ($1, $2, $3::jsonb) RETURNING id, created`,
+	OutboxDelivered: `UPDATE outbox SET delivered=now() WHERE id=$1 AND delivered IS NULL`,
+	OutboxPending: `SELECT id, created, payload::text
+FROM outbox
+WHERE delivered IS NULL
+ORDER BY id
+LIMIT $1
+FOR UPDATE SKIP LOCKED`,
+	Notify: `SELECT pg_notify($1, $2)`,
+	NewestComments: `SELECT e.id, e.title, e.body, e.url, e.created, e.author_id, e.forum, e.deleted, e.locked, e.pinned, a.handle, extract(epoch from (now()-e.created)) seconds, e.upvotes, e.downvotes, parent.ancestor
+FROM entry_closures closure
+JOIN entry e ON e.id=closure.descendant
+JOIN account a ON a.id=e.author_id
+JOIN entry_closures parent ON (
+	parent.descendant=e.id
+	AND parent.depth=1
+)
+WHERE 1=1
+AND closure.ancestor=$1
+AND closure.depth>0
+AND NOT e.deleted
+AND NOT ` + shadowBanned("e") + `
+ORDER BY e.created DESC, e.id DESC
+LIMIT $2`,
+	SubtreeVoteEventsDelete: `DELETE FROM vote_event WHERE entry_id = ANY($1::bigint[])`,
+	SubtreeDeltasDelete:     `DELETE FROM entry_delta WHERE post_id = ANY($1::bigint[])`,
+	SubtreeReportsDelete:    `DELETE FROM report WHERE entry_id = ANY($1::bigint[])`,
+	SubtreeMergesDelete:     `DELETE FROM entry_merge WHERE source_id = ANY($1::bigint[]) OR target_id = ANY($1::bigint[])`,
+	SubtreeOutboxDelete:     `DELETE FROM outbox WHERE entry_id = ANY($1::bigint[])`,
+	SubtreeModLogRedact:     `UPDATE mod_log SET before=$2, after=$2 WHERE entry_id = ANY($1::bigint[])`,
+	KarmaShiftSubtree: karmaUpsert(karmaEntries("(e.upvotes-e.downvotes)*$2::bigint",
+		"entry_closures s JOIN entry e ON e.id=s.descendant",
+		"s.ancestor=$1 AND e.upvotes<>e.downvotes")),
+	LastEdits:              `SELECT post_id, max(modified) FROM entry_delta WHERE post_id = ANY($1::bigint[]) GROUP BY post_id`,
+	EntryShadowed:          `SELECT ` + shadowBanned("e") + ` FROM entry e WHERE e.id=$1`,
+	MentionsForEntryDelete: `DELETE FROM mention WHERE entry_id=$1 RETURNING user_id`,
+	MentionNotificationsCreate: `INSERT INTO notification (user_id, entry_id, actor_id, kind)
+SELECT u, $2, $3, $4
+FROM unnest($1::bigint[]) AS u
+WHERE u <> ALL($5::bigint[])`,
+	SubtreeBansDelete:       `DELETE FROM ban WHERE forum_id = ANY($1::bigint[])`,
+	SubtreeModeratorsDelete: `DELETE FROM moderator WHERE forum_id = ANY($1::bigint[])`,
+	SubtreeKarmaDelete:      `DELETE FROM karma WHERE forum_id = ANY($1::bigint[])`,
+}
+
+//The condition, shared by every query that hides entries of shadow-banned
+//authors, that the entry aliased as alias was written by someone who is
+//shadow-banned from a forum above it
+func shadowBanned(alias string) string {
+	return `EXISTS (
+	-- Entries by authors who are shadow-banned from a forum above them
+	select 1
+	from ban b
+	join entry_closures bc ON bc.ancestor=b.forum_id
+	where bc.descendant=` + alias + `.id
+	AND b.user_id=` + alias + `.author_id
+	AND b.shadow
+	AND (b.expires IS NULL OR b.expires>now())
+)`
+}
+
+//Adds up the points of rows with the columns of karmaEntries, per author and
+//forum, and adds them to the karma table
+func karmaUpsert(entries string) string {
+	return `INSERT INTO karma (user_id, forum_id, post_points, comment_points)
+SELECT t.author_id, t.forum_id, SUM(CASE WHEN t.is_post THEN t.points ELSE 0 END), SUM(CASE WHEN t.is_post THEN 0 ELSE t.points END)
+FROM (
+` + entries + `
+) t
+GROUP BY t.author_id, t.forum_id
+ON CONFLICT (user_id, forum_id) DO UPDATE
+SET post_points = karma.post_points + EXCLUDED.post_points,
+	comment_points = karma.comment_points + EXCLUDED.comment_points`
+}
+
+//Selects, for the non-forum entries e in from that match where, the author,
+//the points expression, the nearest forum above the entry (0 if none) and
+//whether the entry is a post
+func karmaEntries(points, from, where string) string {
+	return `SELECT e.author_id, ` + points + ` points,
+	COALESCE((
+		-- The nearest forum above the entry
+		SELECT ec.ancestor
+		FROM entry_closures ec
+		JOIN entry f ON f.id=ec.ancestor
+		WHERE ec.descendant=e.id
+		AND ec.depth>0
+		AND f.forum
+		ORDER BY ec.depth ASC
+		LIMIT 1
+	), 0) forum_id,
+	EXISTS (
+		-- Posts are entries whose parent is a forum; everything else is a comment
+		SELECT 1
+		FROM entry_closures p
+		JOIN entry f ON f.id=p.ancestor
+		WHERE p.descendant=e.id
+		AND p.depth=1
+		AND f.forum
+	) is_post
+FROM ` + from + `
+WHERE ` + where + `
+AND NOT e.forum`
 }
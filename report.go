@@ -0,0 +1,49 @@
+/*
+Reports let users flag abusive entries for moderators. Open reports against the
+same entry are grouped together in the moderation queue of the forum the entry
+sits under, and are closed together when a moderator resolves them.
+
+For report methods and functions that access a database, see report_db.go
+*/
+package forum
+
+import (
+	"time"
+)
+
+const (
+	RESOLVE_DISMISS = "dismiss" //The reports were unfounded; nothing is done to the entry
+	RESOLVE_REMOVE  = "remove"  //The entry is soft-deleted
+	RESOLVE_LOCK    = "lock"    //The entry is locked against new replies
+	RESOLVE_BAN     = "ban"     //The entry's author is banned from the forum
+
+	REPORTS_PER_PAGE = 50 //Number of entries on one page of a moderation queue
+)
+
+type EntryReport struct {
+	Id             int64     //The ID of this report
+	EntryId        int64     //The ID of the reported entry
+	ForumId        int64     //The ID of the forum the entry sat under when it was reported
+	ReporterId     int64     //The ID of the user who filed the report
+	ReporterHandle string    //Name of the user who filed the report
+	Reason         string    //Why the entry was reported
+	Created        time.Time //Time at which the report was filed
+	Resolution     string    //One of the RESOLVE_* constants, or empty while the report is open
+	ResolverId     int64     //The ID of the moderator who resolved the report
+	Note           string    //The moderator's note on the resolution
+	Resolved       time.Time //Time at which the report was resolved; zero while it is open
+}
+
+//Whether the report is still waiting for a moderator
+func (r *EntryReport) Open() bool {
+	return r.Resolution == ""
+}
+
+//One entry in a moderation queue, summarizing all of its open reports
+type ReportSummary struct {
+	Entry         *Entry    //The reported entry
+	ForumId       int64     //The ID of the forum the entry sits under
+	Reports       int64     //Number of open reports against the entry
+	FirstReported time.Time //Time at which the oldest open report was filed
+	LastReported  time.Time //Time at which the newest open report was filed
+}
@@ -0,0 +1,180 @@
+/*
+Report methods and functions that access a database are placed here.
+*/
+package forum
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Files a report against an entry on behalf of reporter. The report is scoped
+// to the nearest forum that the entry sits under, so entries outside of any
+// forum cannot be reported: no moderation queue would ever show them. Reporting
+// an entry that the reporter already has an open report against does nothing.
+func Report(entryId int64, reporter User, reason string) error {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return errors.New("Please give a reason for reporting this entry.")
+	}
+
+	var exists bool
+	if err := Config.DB.QueryRow(queries.EntryExists, entryId).Scan(&exists); err != nil || !exists {
+		return errors.New("Error: The entry to be reported could not be found.")
+	}
+
+	forumId, err := forumOf(Config.DB, entryId)
+	if err != nil {
+		return err
+	}
+	if forumId == 0 {
+		return errors.New("Error: The entry does not sit under a forum, so there is no moderator to report it to.")
+	}
+
+	_, err = Config.DB.Exec(queries.ReportCreate, entryId, forumId, reporter.GetId(), reason)
+	if err != nil {
+		return errors.New("Error: Your report could not be stored.")
+	}
+
+	return nil
+}
+
+// Retrieves one page (starting from 0) of the moderation queue for a forum and
+// its sub-forums: every entry with open reports, most-reported first.
+func ReportQueue(forumId int64, page int) ([]*ReportSummary, error) {
+	if page < 0 {
+		page = 0
+	}
+
+	rows, err := Config.DB.Query(queries.ReportQueue, forumId, REPORTS_PER_PAGE, page*REPORTS_PER_PAGE)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	queue := make([]*ReportSummary, 0)
+	for rows.Next() {
+		s := &ReportSummary{Entry: New()}
+		e := s.Entry
+		err = rows.Scan(&e.Id, &e.Title, &e.Body, &e.Url, &e.Created, &e.AuthorId, &e.Forum, &e.Deleted, &e.Locked, &e.Pinned, &e.AuthorHandle, &e.Seconds, &s.ForumId, &s.Reports, &s.FirstReported, &s.LastReported)
+		if err != nil {
+			return nil, err
+		}
+
+		queue = append(queue, s)
+	}
+
+	return queue, rows.Err()
+}
+
+// Retrieves every report filed against an entry, open or resolved, newest first.
+func EntryReports(entryId int64) ([]*EntryReport, error) {
+	rows, err := Config.DB.Query(queries.EntryReports, entryId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := make([]*EntryReport, 0)
+	for rows.Next() {
+		r := new(EntryReport)
+		var resolved sql.NullTime
+		err = rows.Scan(&r.Id, &r.EntryId, &r.ForumId, &r.ReporterId, &r.ReporterHandle, &r.Reason, &r.Created, &r.Resolution, &r.ResolverId, &r.Note, &resolved)
+		if err != nil {
+			return nil, err
+		}
+		if resolved.Valid {
+			r.Resolved = resolved.Time
+		}
+
+		reports = append(reports, r)
+	}
+
+	return reports, rows.Err()
+}
+
+// Closes every open report against an entry. Action is one of the RESOLVE_*
+// constants and is carried out in the same transaction that records it.
+func ResolveReports(entryId int64, by User, action, note string) error {
+	note = strings.TrimSpace(note)
+
+	switch action {
+	case RESOLVE_DISMISS, RESOLVE_REMOVE, RESOLVE_LOCK, RESOLVE_BAN:
+	default:
+		return errors.New("Error: Unknown resolution '" + action + "'.")
+	}
+
+	//Wrap in a transaction
+	tx, err := Config.DB.Begin()
+	if err != nil {
+		return errors.New("Error: We had a database problem trying to resolve the reports.")
+	}
+
+	ev, err := resolveReports(tx, entryId, by, action, note)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.New("Error: The reports could not be resolved.")
+	}
+
+	dispatch(ev)
+
+	return nil
+}
+
+//Carries out a resolution the same way Delete and SetLocked would, and returns
+//the event to dispatch once the transaction commits
+func resolveReports(tx *sql.Tx, entryId int64, by User, action, note string) (*Event, error) {
+	e, err := entryForUpdate(tx, entryId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = authorize(tx, by, ACTION_RESOLVE, e); err != nil {
+		return nil, err
+	}
+
+	var ev *Event
+	switch action {
+	case RESOLVE_REMOVE:
+		if ev, err = softDelete(tx, e, by, note); err != nil {
+			return nil, err
+		}
+	case RESOLVE_LOCK:
+		if ev, err = writeFlag(tx, queries.EntryLock, LOG_LOCK, e, true, by); err != nil {
+			return nil, err
+		}
+	case RESOLVE_BAN:
+		var forumId int64
+		if forumId, err = forumOf(tx, entryId); err != nil {
+			return nil, err
+		}
+		if forumId == 0 {
+			return nil, errors.New("Error: The entry does not sit under a forum, so its author cannot be banned from one.")
+		}
+		if err = banUser(tx, forumId, e.AuthorId, by, time.Time{}, false); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := tx.Exec(queries.ReportsResolve, entryId, action, by.GetId(), note)
+	if err != nil {
+		return nil, errors.New("Error: The reports could not be resolved.")
+	}
+	n, err := res.RowsAffected()
+	if err == nil && n == 0 {
+		return nil, errors.New("Error: There are no open reports against this entry.")
+	}
+
+	if err = logAction(tx, by, entryId, LOG_RESOLVE, fmt.Sprintf("%d open reports", n), action+": "+note); err != nil {
+		return nil, err
+	}
+
+	return ev, nil
+}
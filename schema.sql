@@ -0,0 +1,175 @@
+-- The columns and tables this package uses beyond the original entry,
+-- entry_closures, vote and account tables. Apply once to an existing database,
+-- then call RepairVoteCounts() and RepairKarma() once so that the vote
+-- counters and karma reflect the votes already cast; until then every entry
+-- reads 0/0 and every user has no karma.
+
+ALTER TABLE entry
+	ADD COLUMN IF NOT EXISTS deleted boolean NOT NULL DEFAULT false,
+	ADD COLUMN IF NOT EXISTS deleted_by bigint REFERENCES account (id),
+	ADD COLUMN IF NOT EXISTS deleted_reason text,
+	ADD COLUMN IF NOT EXISTS deleted_at timestamptz,
+	ADD COLUMN IF NOT EXISTS locked boolean NOT NULL DEFAULT false,
+	ADD COLUMN IF NOT EXISTS pinned boolean NOT NULL DEFAULT false,
+	-- Merged threads point at the thread they were merged into
+	ADD COLUMN IF NOT EXISTS redirect_id bigint REFERENCES entry (id) ON DELETE SET NULL,
+	ADD COLUMN IF NOT EXISTS upvotes bigint NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS downvotes bigint NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS entry_author_idx ON entry (author_id, created DESC);
+CREATE INDEX IF NOT EXISTS entry_search_idx ON entry USING gin (to_tsvector('english', title || ' ' || body));
+
+CREATE TABLE IF NOT EXISTS vote_event (
+	id bigserial PRIMARY KEY,
+	entry_id bigint NOT NULL REFERENCES entry (id),
+	user_id bigint NOT NULL REFERENCES account (id),
+	value smallint NOT NULL,
+	previous smallint NOT NULL,
+	created timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS vote_event_user_idx ON vote_event (user_id, created);
+CREATE INDEX IF NOT EXISTS vote_event_entry_idx ON vote_event (entry_id, created);
+
+CREATE TABLE IF NOT EXISTS entry_delta (
+	id bigserial PRIMARY KEY,
+	post_id bigint NOT NULL REFERENCES entry (id),
+	title_delta text NOT NULL DEFAULT '',
+	body_delta text NOT NULL DEFAULT '',
+	modifier_id bigint NOT NULL REFERENCES account (id),
+	modified timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS entry_delta_post_idx ON entry_delta (post_id, modified);
+
+CREATE TABLE IF NOT EXISTS entry_preview (
+	entry_id bigint PRIMARY KEY REFERENCES entry (id),
+	url text NOT NULL,
+	title text NOT NULL DEFAULT '',
+	description text NOT NULL DEFAULT '',
+	image text NOT NULL DEFAULT '',
+	canonical text NOT NULL DEFAULT '',
+	fetched timestamptz NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS entry_merge (
+	id bigserial PRIMARY KEY,
+	source_id bigint NOT NULL REFERENCES entry (id),
+	target_id bigint NOT NULL REFERENCES entry (id),
+	merged_by bigint NOT NULL REFERENCES account (id),
+	votes_moved boolean NOT NULL,
+	created timestamptz NOT NULL DEFAULT now()
+);
+
+-- Not keyed to entry: the entry is gone by the time the row matters
+CREATE TABLE IF NOT EXISTS entry_takedown (
+	id bigserial PRIMARY KEY,
+	entry_id bigint NOT NULL,
+	entry_count bigint NOT NULL,
+	deleted_by bigint NOT NULL REFERENCES account (id),
+	reason text NOT NULL,
+	created timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS moderator (
+	forum_id bigint NOT NULL REFERENCES entry (id),
+	user_id bigint NOT NULL REFERENCES account (id),
+	appointed_by bigint NOT NULL REFERENCES account (id),
+	created timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (forum_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS ban (
+	id bigserial PRIMARY KEY,
+	forum_id bigint NOT NULL REFERENCES entry (id),
+	user_id bigint NOT NULL REFERENCES account (id),
+	banned_by bigint NOT NULL REFERENCES account (id),
+	-- NULL for a permanent ban
+	expires timestamptz,
+	shadow boolean NOT NULL DEFAULT false,
+	created timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS ban_user_idx ON ban (user_id, forum_id);
+
+-- forum_id is the forum the entry sat under when it was reported
+CREATE TABLE IF NOT EXISTS report (
+	id bigserial PRIMARY KEY,
+	entry_id bigint NOT NULL REFERENCES entry (id),
+	forum_id bigint NOT NULL,
+	reporter_id bigint NOT NULL REFERENCES account (id),
+	reason text NOT NULL,
+	created timestamptz NOT NULL DEFAULT now(),
+	resolution text,
+	resolved_by bigint REFERENCES account (id),
+	note text,
+	resolved_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS report_open_idx ON report (forum_id, entry_id) WHERE resolved_at IS NULL;
+
+-- Kept after hard deletions (with its text redacted), so not keyed to entry;
+-- forum_id is 0 for entries outside any forum
+CREATE TABLE IF NOT EXISTS mod_log (
+	id bigserial PRIMARY KEY,
+	forum_id bigint NOT NULL,
+	actor_id bigint NOT NULL REFERENCES account (id),
+	entry_id bigint NOT NULL,
+	action text NOT NULL,
+	before text NOT NULL,
+	after text NOT NULL,
+	created timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS mod_log_forum_idx ON mod_log (forum_id, created DESC);
+CREATE INDEX IF NOT EXISTS mod_log_actor_idx ON mod_log (actor_id, created DESC);
+CREATE INDEX IF NOT EXISTS mod_log_entry_idx ON mod_log (entry_id);
+
+-- forum_id is 0 for entries outside any forum
+CREATE TABLE IF NOT EXISTS karma (
+	user_id bigint NOT NULL REFERENCES account (id),
+	forum_id bigint NOT NULL,
+	post_points bigint NOT NULL DEFAULT 0,
+	comment_points bigint NOT NULL DEFAULT 0,
+	UNIQUE (user_id, forum_id)
+);
+CREATE INDEX IF NOT EXISTS karma_forum_idx ON karma (forum_id);
+
+CREATE TABLE IF NOT EXISTS notification (
+	id bigserial PRIMARY KEY,
+	user_id bigint NOT NULL REFERENCES account (id),
+	entry_id bigint NOT NULL REFERENCES entry (id),
+	actor_id bigint NOT NULL REFERENCES account (id),
+	kind text NOT NULL,
+	created timestamptz NOT NULL DEFAULT now(),
+	read boolean NOT NULL DEFAULT false
+);
+CREATE INDEX IF NOT EXISTS notification_user_idx ON notification (user_id, created DESC);
+
+CREATE TABLE IF NOT EXISTS mention (
+	entry_id bigint NOT NULL REFERENCES entry (id),
+	user_id bigint NOT NULL REFERENCES account (id),
+	PRIMARY KEY (entry_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS subscription (
+	user_id bigint NOT NULL REFERENCES account (id),
+	entry_id bigint NOT NULL REFERENCES entry (id),
+	muted boolean NOT NULL DEFAULT false,
+	created timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (user_id, entry_id)
+);
+CREATE INDEX IF NOT EXISTS subscription_entry_idx ON subscription (entry_id);
+
+CREATE TABLE IF NOT EXISTS thread_visit (
+	user_id bigint NOT NULL REFERENCES account (id),
+	entry_id bigint NOT NULL REFERENCES entry (id),
+	seen timestamptz NOT NULL,
+	PRIMARY KEY (user_id, entry_id)
+);
+
+-- Not keyed to entry: the event about a hard deletion outlives the entry
+CREATE TABLE IF NOT EXISTS outbox (
+	id bigserial PRIMARY KEY,
+	kind text NOT NULL,
+	entry_id bigint NOT NULL,
+	payload jsonb NOT NULL,
+	created timestamptz NOT NULL DEFAULT now(),
+	delivered timestamptz
+);
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (id) WHERE delivered IS NULL;
@@ -0,0 +1,40 @@
+/*
+Search finds posts and comments by the words in their titles and bodies, using
+Postgres full-text search. Hits are ranked by relevance and carry a snippet of
+the body with the matching words highlighted, along with the thread they are in.
+
+The search document of an entry is to_tsvector('english', title || ' ' || body),
+so an expression index on exactly that keeps searches fast.
+
+For search methods and functions that access a database, see search_db.go
+*/
+package forum
+
+import (
+	"html"
+	"strings"
+)
+
+const (
+	SEARCH_RESULTS_PER_PAGE = 25 //Number of hits on one page of search results
+
+	//Marks the start and end of each match in a snippet as it comes from the
+	//database. They are private-use characters, so unlikely to appear in a body.
+	highlightStart = "\ue000"
+	highlightStop  = "\ue001"
+)
+
+type SearchHit struct {
+	Entry       *Entry  //The matching post or comment, with the viewer's vote in UserVote
+	Rank        float64 //Relevance of the entry to the query; higher is better
+	Snippet     string  //HTML-escaped excerpt of the body, with each match wrapped in <mark>
+	ThreadId    int64   //The ID of the post at the root of the entry's thread; the entry's own ID if it is a post
+	ThreadTitle string  //Title of the post at the root of the entry's thread
+}
+
+//Escapes a snippet for HTML and turns the database's highlight markers into <mark> tags
+func highlightSnippet(snippet string) string {
+	snippet = html.EscapeString(snippet)
+
+	return strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>").Replace(snippet)
+}
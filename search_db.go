@@ -0,0 +1,50 @@
+/*
+Search methods and functions that access a database are placed here.
+*/
+package forum
+
+import (
+	"strings"
+)
+
+// Retrieves one page (starting from 0) of the posts and comments matching
+// query, most relevant first, as seen by viewer. The query may use quotes for
+// phrases, "or" and a leading - to exclude words. If forumId is not 0, only
+// entries beneath that forum are searched. Deleted entries are never found, nor
+// are the entries of shadow-banned authors unless the viewer is the author.
+func Search(query string, forumId int64, viewer User, page int) ([]*SearchHit, error) {
+	hits := make([]*SearchHit, 0, SEARCH_RESULTS_PER_PAGE)
+
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return hits, nil
+	}
+
+	if page < 0 {
+		page = 0
+	}
+
+	options := "StartSel=" + highlightStart + ", StopSel=" + highlightStop + ", MaxFragments=2, MaxWords=30, MinWords=10"
+
+	rows, err := Config.DB.Query(queries.Search, query, forumId, viewer.GetId(), SEARCH_RESULTS_PER_PAGE, page*SEARCH_RESULTS_PER_PAGE, options)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		h := &SearchHit{Entry: New()}
+		e := h.Entry
+		err = rows.Scan(&e.Id, &e.Title, &e.Body, &e.Url, &e.Created, &e.AuthorId, &e.Forum, &e.Deleted, &e.Locked, &e.Pinned, &e.AuthorHandle, &e.Seconds, &e.Upvotes, &e.Downvotes, &e.UserVote.Upvote, &e.UserVote.Downvote,
+			&h.ThreadId, &h.ThreadTitle, &h.Rank, &h.Snippet)
+		if err != nil {
+			return nil, err
+		}
+		e.UserVote.EntryId, e.UserVote.UserId = e.Id, viewer.GetId()
+		h.Snippet = highlightSnippet(h.Snippet)
+
+		hits = append(hits, h)
+	}
+
+	return hits, rows.Err()
+}
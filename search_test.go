@@ -0,0 +1,21 @@
+package forum
+
+import (
+	"testing"
+)
+
+func TestHighlightSnippet(t *testing.T) {
+	cases := map[string]string{
+		"":           "",
+		"no matches": "no matches",
+		"a " + highlightStart + "match" + highlightStop + " here": "a <mark>match</mark> here",
+		"<script>" + highlightStart + "x" + highlightStop:         "&lt;script&gt;<mark>x</mark>",
+		"Tom & \"Jerry\"": "Tom &amp; &#34;Jerry&#34;",
+	}
+
+	for in, want := range cases {
+		if got := highlightSnippet(in); got != want {
+			t.Errorf("highlightSnippet(%q) = %q, expected %q", in, got, want)
+		}
+	}
+}
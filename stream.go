@@ -0,0 +1,187 @@
+/*
+Streams push changes in a thread to its readers as they happen, using
+Server-Sent Events. A Broker is an EventSink: set it as Config.Events (on its
+own, or alongside other sinks in EventSinks) and serve it over HTTP. Each client
+follows one entry, usually a thread's post, and receives an event for every
+change to that entry or anything beneath it.
+
+A Broker only hears about changes made in its own process. When several
+processes share a database, set Config.Events to a NotifySink in each of them
+instead, LISTEN on its channel, and Relay the notifications into each Broker.
+
+For stream methods and functions that access a database, see stream_db.go
+*/
+package forum
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	STREAM_BUFFER    = 16               //Messages held for a slow client before further ones are dropped
+	STREAM_KEEPALIVE = 30 * time.Second //Interval between comments that keep idle connections open
+)
+
+//What a client receives for each event. For new and edited entries, and for
+//votes, Entry is the entry as it now stands, including its vote counts.
+type StreamMessage struct {
+	Event *Event
+	Entry *Entry `json:",omitempty"`
+}
+
+type Broker struct {
+	mu      sync.Mutex
+	clients map[int64]map[chan []byte]bool //k: followed entry ID => v: set of client channels
+
+	load func(ev *Event) (*Entry, error) //Retrieves the entry to send along with an event, or nil
+}
+
+func NewBroker() *Broker {
+	return &Broker{clients: make(map[int64]map[chan []byte]bool), load: loadEventEntry}
+}
+
+// Sends an event to every client following the entry it happened to or one of
+// that entry's ancestors. Clients that are not keeping up miss the event rather
+// than hold up everyone else.
+func (b *Broker) Publish(ev *Event) error {
+	b.mu.Lock()
+	targets := make([]chan []byte, 0)
+	for _, id := range append([]int64{ev.EntryId}, ev.Ancestors...) {
+		for c := range b.clients[id] {
+			targets = append(targets, c)
+		}
+	}
+	b.mu.Unlock()
+
+	if len(targets) == 0 {
+		return nil
+	}
+
+	e, err := b.load(ev)
+	if err == sql.ErrNoRows {
+		//The entry is hidden from its readers (e.g. by a shadow ban), or is already gone
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(&StreamMessage{Event: ev, Entry: e})
+	if err != nil {
+		return err
+	}
+	msg := []byte(fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", ev.Id, ev.Kind, data))
+
+	for _, c := range targets {
+		select {
+		case c <- msg:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Reads events, as JSON payloads from a NotifySink, and publishes each one.
+// Payloads that cannot be read or published are passed to onError, if it is
+// not nil, and skipped. Returns when payloads is closed.
+func (b *Broker) Relay(payloads <-chan string, onError func(error)) {
+	for payload := range payloads {
+		p := &notifyPayload{Event: new(Event)}
+		err := json.Unmarshal([]byte(payload), p)
+		if err == nil {
+			ev := p.Event
+			ev.Id, ev.Created = p.Id, p.Created
+			err = b.Publish(ev)
+		}
+
+		if err != nil && onError != nil {
+			onError(err)
+		}
+	}
+}
+
+//Registers a client following entryId; the returned func unregisters it
+func (b *Broker) follow(entryId int64) (chan []byte, func()) {
+	c := make(chan []byte, STREAM_BUFFER)
+
+	b.mu.Lock()
+	if b.clients[entryId] == nil {
+		b.clients[entryId] = make(map[chan []byte]bool)
+	}
+	b.clients[entryId][c] = true
+	b.mu.Unlock()
+
+	return c, func() {
+		b.mu.Lock()
+		delete(b.clients[entryId], c)
+		if len(b.clients[entryId]) == 0 {
+			delete(b.clients, entryId)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// Streams events for the entry named by the "entry" query parameter, e.g.
+// /stream?entry=42, until the client goes away.
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	entryId, err := strconv.ParseInt(r.URL.Query().Get("entry"), 10, 64)
+	if err != nil || entryId <= 0 {
+		http.Error(w, "Error: Please say which entry to follow.", http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Error: Streaming is not supported.", http.StatusInternalServerError)
+		return
+	}
+
+	c, unfollow := b.follow(entryId)
+	defer unfollow()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	fmt.Fprint(w, ": following "+strconv.FormatInt(entryId, 10)+"\n\n")
+	flusher.Flush()
+
+	keepalive := time.NewTicker(STREAM_KEEPALIVE)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg := <-c:
+			if _, err = w.Write(msg); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			if _, err = fmt.Fprint(w, ":\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// Publishes each event to every sink in turn. Every sink is tried; the first
+// error, if any, is returned, so a failed event is retried for all of them.
+type EventSinks []EventSink
+
+func (sinks EventSinks) Publish(ev *Event) error {
+	var first error
+	for _, sink := range sinks {
+		if err := sink.Publish(ev); err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
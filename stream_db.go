@@ -0,0 +1,46 @@
+/*
+Stream methods and functions that access a database are placed here.
+*/
+package forum
+
+import (
+	"encoding/json"
+	"time"
+)
+
+//Retrieves the entry an event is about, for the kinds of event whose clients
+//need it: new and edited entries, and votes, which change the entry's counts.
+//Entries hidden from anonymous viewers are not found (sql.ErrNoRows).
+func loadEventEntry(ev *Event) (*Entry, error) {
+	switch ev.Kind {
+	case EVENT_ENTRY_CREATED, EVENT_ENTRY_EDITED, EVENT_VOTE_CAST, EVENT_VOTE_RETRACTED:
+		return OneEntry(ev.EntryId)
+	}
+
+	return nil, nil
+}
+
+// An EventSink that sends each event, as a JSON payload, to every session
+// listening on a Postgres notification channel, so that events reach the
+// Brokers of every process sharing the database.
+type NotifySink struct {
+	Channel string //The channel to NOTIFY
+}
+
+//An event as sent by NotifySink: unlike in the outbox, its ID and time go along with it
+type notifyPayload struct {
+	Id      int64
+	Created time.Time
+	*Event
+}
+
+func (s *NotifySink) Publish(ev *Event) error {
+	payload, err := json.Marshal(&notifyPayload{Id: ev.Id, Created: ev.Created, Event: ev})
+	if err != nil {
+		return err
+	}
+
+	_, err = Config.DB.Exec(queries.Notify, s.Channel, string(payload))
+
+	return err
+}
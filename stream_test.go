@@ -0,0 +1,133 @@
+package forum
+
+import (
+	"bufio"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBrokerStream(t *testing.T) {
+	b := NewBroker()
+	b.load = func(ev *Event) (*Entry, error) {
+		return &Entry{Id: ev.EntryId, Title: "Reply", Upvotes: 3}, nil
+	}
+
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?entry=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Got Content-Type %q, expected text/event-stream", ct)
+	}
+
+	r := bufio.NewReader(resp.Body)
+	readLine := func() string {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSuffix(line, "\n")
+	}
+
+	//The client is following once the greeting arrives
+	if line := readLine(); line != ": following 1" {
+		t.Fatalf("Got %q, expected the greeting", line)
+	}
+	readLine()
+
+	//Not beneath entry 1, so not sent
+	if err = b.Publish(&Event{Id: 6, Kind: EVENT_ENTRY_CREATED, EntryId: 9, Ancestors: []int64{2}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.Publish(&Event{Id: 7, Kind: EVENT_ENTRY_CREATED, EntryId: 5, Ancestors: []int64{3, 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if line := readLine(); line != "id: 7" {
+		t.Errorf("Got %q, expected id: 7", line)
+	}
+	if line := readLine(); line != "event: "+EVENT_ENTRY_CREATED {
+		t.Errorf("Got %q, expected event: %s", line, EVENT_ENTRY_CREATED)
+	}
+	line := readLine()
+	if !strings.HasPrefix(line, "data: ") || !strings.Contains(line, `"EntryId":5`) || !strings.Contains(line, `"Title":"Reply"`) {
+		t.Errorf("Got %q, expected the event and its entry", line)
+	}
+}
+
+func TestBrokerStreamBadRequest(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewBroker().ServeHTTP(rec, httptest.NewRequest("GET", "/?entry=x", nil))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Got status %d, expected %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestBrokerPublishWithoutClients(t *testing.T) {
+	b := NewBroker()
+	b.load = func(ev *Event) (*Entry, error) {
+		t.Error("Loaded an entry with nobody to send it to")
+		return nil, nil
+	}
+
+	if err := b.Publish(&Event{Kind: EVENT_VOTE_CAST, EntryId: 1}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBrokerPublishHiddenEntry(t *testing.T) {
+	b := NewBroker()
+	b.load = func(ev *Event) (*Entry, error) {
+		return nil, sql.ErrNoRows
+	}
+
+	c, unfollow := b.follow(1)
+	defer unfollow()
+
+	if err := b.Publish(&Event{Id: 8, Kind: EVENT_ENTRY_EDITED, EntryId: 5, Ancestors: []int64{1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-c:
+		t.Errorf("Got %q, expected nothing for a hidden entry", msg)
+	default:
+	}
+}
+
+func TestBrokerRelay(t *testing.T) {
+	b := NewBroker()
+	b.load = func(ev *Event) (*Entry, error) { return nil, nil }
+
+	c, unfollow := b.follow(1)
+	defer unfollow()
+
+	payloads := make(chan string, 2)
+	payloads <- "not json"
+	payloads <- `{"Id":9,"Kind":"entry.deleted","EntryId":5,"Ancestors":[1]}`
+	close(payloads)
+
+	errs := 0
+	b.Relay(payloads, func(error) { errs++ })
+
+	if errs != 1 {
+		t.Errorf("Got %d errors, expected 1", errs)
+	}
+	select {
+	case msg := <-c:
+		if !strings.HasPrefix(string(msg), "id: 9\nevent: entry.deleted\n") {
+			t.Errorf("Got %q", msg)
+		}
+	default:
+		t.Error("Got nothing, expected the relayed event")
+	}
+}
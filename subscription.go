@@ -0,0 +1,21 @@
+/*
+Subscriptions let a user follow a thread or a whole forum, and be notified of
+every new entry beneath it. A subscription can also be muted, which silences a
+sub-thread of something the user follows: for each new entry, only the
+subscription nearest to it counts.
+
+For subscription methods and functions that access a database, see subscription_db.go
+*/
+package forum
+
+import (
+	"time"
+)
+
+type Subscription struct {
+	UserId     int64     //The ID of the subscribed user
+	EntryId    int64     //The ID of the followed (or muted) entry
+	Muted      bool      //Does this subscription silence the entry instead of following it?
+	Created    time.Time //Time at which the user subscribed or muted
+	EntryTitle string    //Title of the followed entry; not persisted to the subscription table
+}
@@ -0,0 +1,63 @@
+/*
+Subscription methods and functions that access a database are placed here.
+*/
+package forum
+
+import (
+	"errors"
+)
+
+// Makes user follow an entry, so that they are notified of every new entry
+// beneath it. Subscribing to a muted entry unmutes it.
+func Subscribe(user User, entryId int64) error {
+	return subscribe(user, entryId, false)
+}
+
+// Silences an entry that user would otherwise be notified about through a
+// subscription to one of its ancestors.
+func Mute(user User, entryId int64) error {
+	return subscribe(user, entryId, true)
+}
+
+func subscribe(user User, entryId int64, muted bool) error {
+	var exists bool
+	if err := Config.DB.QueryRow(queries.EntryExists, entryId).Scan(&exists); err != nil || !exists {
+		return errors.New("Error: The entry to be followed could not be found.")
+	}
+
+	if _, err := Config.DB.Exec(queries.SubscriptionUpsert, user.GetId(), entryId, muted); err != nil {
+		return errors.New("Error: Your subscription could not be stored.")
+	}
+
+	return nil
+}
+
+// Removes user's subscription to, or mute of, an entry, if any.
+func Unsubscribe(user User, entryId int64) error {
+	if _, err := Config.DB.Exec(queries.SubscriptionDelete, user.GetId(), entryId); err != nil {
+		return errors.New("Error: Your subscription could not be removed.")
+	}
+
+	return nil
+}
+
+// Retrieves everything user follows or has muted, newest first.
+func Subscriptions(user User) ([]*Subscription, error) {
+	rows, err := Config.DB.Query(queries.Subscriptions, user.GetId())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := make([]*Subscription, 0)
+	for rows.Next() {
+		s := new(Subscription)
+		if err = rows.Scan(&s.UserId, &s.EntryId, &s.Muted, &s.Created, &s.EntryTitle); err != nil {
+			return nil, err
+		}
+
+		subs = append(subs, s)
+	}
+
+	return subs, rows.Err()
+}
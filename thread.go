@@ -0,0 +1,37 @@
+/*
+A thread is a post together with all of its descendants. Threads can be locked,
+which forbids new replies anywhere beneath the locked entry, and they are
+archived once their post is older than Config.ArchiveAfter, which forbids both
+replies and votes.
+
+For thread methods and functions that access a database, see thread_db.go
+*/
+package forum
+
+import (
+	"fmt"
+	"time"
+)
+
+//LockedError is returned when replying beneath a locked entry
+type LockedError struct {
+	EntryId int64 //The locked entry
+}
+
+func (err *LockedError) Error() string {
+	return fmt.Sprintf("Error: The thread has been locked (entry %d); no new replies may be made.", err.EntryId)
+}
+
+//ArchivedError is returned when replying or voting within an archived thread
+type ArchivedError struct {
+	EntryId int64 //The post at the root of the archived thread
+}
+
+func (err *ArchivedError) Error() string {
+	return fmt.Sprintf("Error: The thread has been archived (entry %d); no new replies or votes may be made.", err.EntryId)
+}
+
+//Whether a thread whose post was created at the given time is archived
+func archived(created time.Time) bool {
+	return Config.ArchiveAfter > 0 && time.Since(created) > Config.ArchiveAfter
+}
@@ -0,0 +1,344 @@
+/*
+Thread-level operations that act on a whole subtree at once, such as merging
+duplicate threads or locking them, are placed here. They are built on the same
+closure table machinery that Persist uses.
+*/
+package forum
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Locks or unlocks an entry. While it is locked, no new replies may be made
+// to it or to any of its descendants.
+func (e *Entry) SetLocked(locked bool, by User) error {
+	if err := setFlag(queries.EntryLock, ACTION_LOCK, LOG_LOCK, e.Id, locked, by); err != nil {
+		return err
+	}
+
+	e.Locked = locked
+
+	return nil
+}
+
+// Pins or unpins an entry. Pinned posts are listed first in their forum
+// regardless of their Score.
+func (e *Entry) SetPinned(pinned bool, by User) error {
+	if err := setFlag(queries.EntryPin, ACTION_PIN, LOG_PIN, e.Id, pinned, by); err != nil {
+		return err
+	}
+
+	e.Pinned = pinned
+
+	return nil
+}
+
+//Sets one of the boolean state columns of an entry and logs the change
+func setFlag(query, permission, action string, id int64, value bool, by User) error {
+	//Wrap in a transaction
+	tx, err := Config.DB.Begin()
+	if err != nil {
+		return errors.New("Error: We had a database problem trying to update the entry.")
+	}
+
+	old, err := entryForUpdate(tx, id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = authorize(tx, by, permission, old); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	ev, err := writeFlag(tx, query, action, old, value, by)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.New("Error: The entry could not be updated.")
+	}
+
+	dispatch(ev)
+
+	return nil
+}
+
+//Sets the column of old, which the caller has locked with entryForUpdate, that
+//action (LOG_LOCK or LOG_PIN) is about. Returns the event to dispatch once the
+//transaction commits.
+func writeFlag(tx *sql.Tx, query, action string, old *Entry, value bool, by User) (*Event, error) {
+	before, kind := old.Locked, EVENT_ENTRY_LOCKED
+	if action == LOG_PIN {
+		before, kind = old.Pinned, EVENT_ENTRY_PINNED
+	}
+
+	if err := logAction(tx, by, old.Id, action, strconv.FormatBool(before), strconv.FormatBool(value)); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(query, old.Id, value); err != nil {
+		return nil, errors.New("Error: We had a database problem trying to update the entry.")
+	}
+
+	ev, err := emitUnlessShadowed(tx, kind, old.Id, by.GetId(), int(boolInt(value)))
+	if err != nil {
+		return nil, errors.New("Error: We couldn't record an event for the change, so the entry was not updated.")
+	}
+
+	return ev, nil
+}
+
+// Checks the entry with ID id and each of its ancestors. If replying is set,
+// a *LockedError is returned when any of them is locked. Whether or not
+// replying is set, an *ArchivedError is returned when the thread they belong
+// to is archived. An id of 0 (no parent) is never locked or archived.
+func checkThreadState(tx *sql.Tx, id int64, replying bool) error {
+	rows, err := tx.Query(queries.ThreadState, id)
+	if err != nil {
+		return errors.New("Error: We had a database problem trying to check the state of the thread.")
+	}
+	defer rows.Close()
+
+	var threadId int64
+	var threadCreated time.Time
+	for rows.Next() {
+		var ancestor int64
+		var locked, forum bool
+		var created time.Time
+		if err = rows.Scan(&ancestor, &locked, &forum, &created); err != nil {
+			return errors.New("Error: We had a database problem trying to check the state of the thread.")
+		}
+
+		if replying && locked {
+			return &LockedError{EntryId: ancestor}
+		}
+
+		//Ancestors come nearest first, so the last non-forum one is the thread's post
+		if !forum {
+			threadId, threadCreated = ancestor, created
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return errors.New("Error: We had a database problem trying to check the state of the thread.")
+	}
+
+	if threadId != 0 && archived(threadCreated) {
+		return &ArchivedError{EntryId: threadId}
+	}
+
+	return nil
+}
+
+// Merges the thread rooted at sourceId into the thread rooted at targetId. All
+// of the source's replies are re-parented under the target. If aggregateVotes
+// is set, votes cast on the source are moved to the target (a user who already
+// voted on the target keeps that vote). The source is left in place as a stub
+// whose RedirectId points at the target, and the merge is recorded against by.
+func MergeThreads(sourceId, targetId int64, by User, aggregateVotes bool) error {
+	if sourceId == targetId {
+		return errors.New("Error: A thread cannot be merged into itself.")
+	}
+
+	//Wrap in a transaction
+	tx, err := Config.DB.Begin()
+	if err != nil {
+		return errors.New("Error: We had a database problem trying to merge the threads.")
+	}
+
+	events, err := mergeThreads(tx, sourceId, targetId, by, aggregateVotes)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.New("Error: The threads could not be merged.")
+	}
+
+	dispatch(events...)
+
+	return nil
+}
+
+//Returns the events of the votes that were moved, to dispatch once the transaction commits
+func mergeThreads(tx *sql.Tx, sourceId, targetId int64, by User, aggregateVotes bool) ([]*Event, error) {
+	for _, id := range []int64{sourceId, targetId} {
+		e, err := entryState(tx, queries.EntryState, id)
+		if err != nil {
+			return nil, errors.New("Error: One of the threads to be merged could not be found.")
+		}
+
+		if err = authorize(tx, by, ACTION_MERGE, e); err != nil {
+			return nil, err
+		}
+	}
+
+	//Neither thread may contain the other
+	for _, pair := range [][2]int64{{sourceId, targetId}, {targetId, sourceId}} {
+		var nested bool
+		if err := tx.QueryRow(queries.IsDescendant, pair[0], pair[1]).Scan(&nested); err != nil {
+			return nil, errors.New("Error: We had a database problem trying to merge the threads.")
+		}
+		if nested {
+			return nil, errors.New("Error: A thread cannot be merged with one of its own replies.")
+		}
+	}
+
+	children, err := childIds(tx, sourceId)
+	if err != nil {
+		return nil, errors.New("Error: We had a database problem trying to find the replies to be merged.")
+	}
+
+	for _, child := range children {
+		if err = moveSubtree(tx, child, targetId); err != nil {
+			return nil, err
+		}
+	}
+
+	var events []*Event
+	if aggregateVotes {
+		before, err := pointsOf(tx, sourceId, targetId)
+		if err != nil {
+			return nil, err
+		}
+
+		cast, err := recordVotes(tx, targetId, false, queries.VotesTransfer, sourceId, targetId)
+		if err != nil {
+			return nil, errors.New("Error: We couldn't move the votes to the merged thread.")
+		}
+
+		retracted, err := recordVotes(tx, sourceId, true, queries.VotesForEntryDelete, sourceId)
+		if err != nil {
+			return nil, errors.New("Error: We couldn't move the votes to the merged thread.")
+		}
+		events = append(cast, retracted...)
+
+		if _, err = tx.Exec(queries.VoteCountsRepair, int64Array([]int64{sourceId, targetId})); err != nil {
+			return nil, errors.New("Error: We couldn't count the votes of the merged thread.")
+		}
+
+		after, err := pointsOf(tx, sourceId, targetId)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, id := range []int64{sourceId, targetId} {
+			if err = adjustKarma(tx, id, after[i]-before[i]); err != nil {
+				return nil, errors.New("Error: We couldn't update karma for the merged thread.")
+			}
+		}
+	}
+
+	if _, err = tx.Exec(queries.EntryRedirect, sourceId, targetId); err != nil {
+		return nil, errors.New("Error: We couldn't leave a redirect in place of the merged thread.")
+	}
+
+	if _, err = tx.Exec(queries.MergeCreate, sourceId, targetId, by.GetId(), aggregateVotes); err != nil {
+		return nil, errors.New("Error: The merge could not be recorded.")
+	}
+
+	if err = logAction(tx, by, sourceId, LOG_MERGE, fmt.Sprintf("%d replies", len(children)), fmt.Sprintf("merged into %d", targetId)); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+//Returns the IDs of the immediate descendants of an entry
+func childIds(tx *sql.Tx, id int64) ([]int64, error) {
+	rows, err := tx.Query(queries.ChildIds, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var child int64
+		if err = rows.Scan(&child); err != nil {
+			return nil, err
+		}
+		ids = append(ids, child)
+	}
+
+	return ids, rows.Err()
+}
+
+// Removes every vote cast on an entry. The totals that were removed are
+// written to the moderation log, and each vote is logged and announced as a
+// retraction.
+func ResetVotes(entryId int64, by User) error {
+	//Wrap in a transaction
+	tx, err := Config.DB.Begin()
+	if err != nil {
+		return errors.New("Error: We had a database problem trying to reset the votes.")
+	}
+
+	old, err := entryForUpdate(tx, entryId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = authorize(tx, by, ACTION_RESET_VOTES, old); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var upvotes, downvotes int64
+	if err = tx.QueryRow(queries.VoteTotals, entryId).Scan(&upvotes, &downvotes); err != nil {
+		tx.Rollback()
+		return errors.New("Error: We had a database problem trying to count the votes.")
+	}
+
+	if err = logAction(tx, by, entryId, LOG_VOTE_RESET, fmt.Sprintf("+%d -%d", upvotes, downvotes), "+0 -0"); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = adjustKarma(tx, entryId, downvotes-upvotes); err != nil {
+		tx.Rollback()
+		return errors.New("Error: The author's karma could not be updated, so the votes were not reset.")
+	}
+
+	events, err := recordVotes(tx, entryId, true, queries.VotesForEntryDelete, entryId)
+	if err != nil {
+		tx.Rollback()
+		return errors.New("Error: The votes could not be reset.")
+	}
+
+	if _, err = tx.Exec(queries.VoteCountsReset, entryId); err != nil {
+		tx.Rollback()
+		return errors.New("Error: The votes could not be reset.")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.New("Error: The votes could not be reset.")
+	}
+
+	dispatch(events...)
+
+	return nil
+}
+
+//Returns the points (upvotes less downvotes) of each of the given entries, in order
+func pointsOf(tx *sql.Tx, ids ...int64) ([]int64, error) {
+	points := make([]int64, len(ids))
+	for i, id := range ids {
+		var upvotes, downvotes int64
+		if err := tx.QueryRow(queries.VoteTotals, id).Scan(&upvotes, &downvotes); err != nil {
+			return nil, errors.New("Error: We had a database problem trying to count the votes.")
+		}
+		points[i] = upvotes - downvotes
+	}
+
+	return points, nil
+}
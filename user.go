@@ -1,5 +1,10 @@
 package forum
 
+import (
+	"fmt"
+	"strings"
+)
+
 /*
 Define the methods that a user object must have in order to
 be compatible with this forum system.
@@ -8,3 +13,61 @@ be compatible with this forum system.
 type User interface {
 	GetId() int64
 }
+
+/*
+A user object may optionally implement the interfaces below to take part in
+authorization. Every operation that changes the forum consults them before
+falling back to the default policy:
+
+	ACTION_POST, ACTION_VOTE      anyone
+	ACTION_EDIT, ACTION_DELETE    the entry's author, or a moderator
+	everything else               a moderator of the entry (for top-level
+	                              entries, which have none, an Administrator)
+
+A moderator is an Administrator whose IsAdmin() is true, or a user who has been
+appointed to moderate a forum that the entry sits under (see AddModerator).
+*/
+
+//An Authorizer decides for itself whether it may perform an action on an entry.
+//Its answer is final; the default policy is not consulted.
+type Authorizer interface {
+	Can(action string, e *Entry) bool
+}
+
+//An Administrator whose IsAdmin() is true moderates every forum
+type Administrator interface {
+	IsAdmin() bool
+}
+
+const (
+	ACTION_POST         = "post"         //Reply to the entry, or create a top-level entry if there is none
+	ACTION_CREATE_FORUM = "create_forum" //Create a sub-forum beneath the entry, or a top-level forum if there is none
+	ACTION_VOTE         = "vote"         //Vote on the entry
+	ACTION_EDIT         = "edit"         //Change the entry's title or body
+	ACTION_DELETE       = "delete"       //Soft-delete the entry
+	ACTION_HARD_DELETE  = "hard_delete"  //Permanently remove the entry and its replies
+	ACTION_MOVE         = "move"         //Move the entry to a new parent
+	ACTION_MERGE        = "merge"        //Merge the entry's thread into another
+	ACTION_LOCK         = "lock"         //Lock or unlock the entry
+	ACTION_PIN          = "pin"          //Pin or unpin the entry
+	ACTION_RESET_VOTES  = "reset_votes"  //Remove every vote on the entry
+	ACTION_RESOLVE      = "resolve"      //Resolve the reports against the entry
+	ACTION_APPOINT      = "appoint"      //Appoint or dismiss moderators of the forum
+	ACTION_BAN          = "ban"          //Ban users from the forum, or lift their bans
+)
+
+//PermissionError is returned when a user may not perform an action on an entry
+type PermissionError struct {
+	Action  string //One of the ACTION_* constants
+	EntryId int64  //The entry that was to be acted upon, or 0 for none
+	UserId  int64  //The user who attempted the action
+}
+
+func (err *PermissionError) Error() string {
+	return fmt.Sprintf("Error: You are not allowed to %s entry %d.", strings.Replace(err.Action, "_", " ", -1), err.EntryId)
+}
+
+//userId lets the package act on behalf of a user when all it has is their ID
+type userId int64
+
+func (u userId) GetId() int64 { return int64(u) }
@@ -0,0 +1,59 @@
+/*
+Visits record when a user last looked at a thread, so that what was posted
+since can be pointed out to them. A visit to an entry counts as a visit to
+everything beneath it, so marking a forum as seen marks all of its threads.
+Entries are only ever new relative to a visit: nothing is new in a thread the
+user has never visited.
+*/
+package forum
+
+import (
+	"errors"
+)
+
+// Records that user has just seen the entry and all of its descendants.
+func MarkSeen(user User, entryId int64) error {
+	if _, err := Config.DB.Exec(queries.VisitUpsert, user.GetId(), entryId); err != nil {
+		return errors.New("Error: We had a database problem trying to record your visit.")
+	}
+
+	return nil
+}
+
+//Sets NewCount on each of the given entries to the number of their descendants
+//that were created since user last visited them. Meant for flat lists such as
+//those from ForumPosts, whose descendants are not loaded.
+func AttachNewCounts(user User, entries ...*Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(entries))
+	for i, e := range entries {
+		ids[i] = e.Id
+	}
+
+	rows, err := Config.DB.Query(queries.NewCounts, user.GetId(), int64Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	counts := make(map[int64]int64, len(ids))
+	for rows.Next() {
+		var id, count int64
+		if err = rows.Scan(&id, &count); err != nil {
+			return err
+		}
+		counts[id] = count
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		e.NewCount = counts[e.Id]
+	}
+
+	return nil
+}
@@ -7,11 +7,22 @@ hierarchical nesting) are abundant.
 package forum
 
 import (
+	"database/sql"
 	"errors"
-	"fmt"
 	"time"
 )
 
+const (
+	VOTE_DOWN = -1 //The value of a downvote
+	VOTE_NONE = 0  //The value of no vote at all, e.g. after retracting one
+	VOTE_UP   = 1  //The value of an upvote
+)
+
+var (
+	ErrInvalidVote = errors.New("Error: A vote must be up, down or neither.")
+	ErrSelfVote    = errors.New("Error: You cannot vote on your own entries.")
+)
+
 type Vote struct {
 	//Id       int64     //The ID of this vote
 	EntryId  int64     //The ID of the post
@@ -21,32 +32,212 @@ type Vote struct {
 	Created  time.Time //Time at which the vote was cast
 }
 
-// Stores an entry to the database and correctly builds its ancestry based
-// on its parent's ID.
+//Value returns VOTE_UP, VOTE_DOWN or VOTE_NONE
+func (v *Vote) Value() int {
+	switch {
+	case v.Upvote && !v.Downvote:
+		return VOTE_UP
+	case v.Downvote && !v.Upvote:
+		return VOTE_DOWN
+	}
+
+	return VOTE_NONE
+}
+
+//SetValue sets Upvote and Downvote from one of VOTE_UP, VOTE_DOWN or VOTE_NONE
+func (v *Vote) SetValue(value int) error {
+	switch value {
+	case VOTE_UP, VOTE_DOWN, VOTE_NONE:
+		v.Upvote, v.Downvote = value == VOTE_UP, value == VOTE_DOWN
+		return nil
+	}
+
+	return ErrInvalidVote
+}
+
+// Records voter's vote on an entry. Value is one of VOTE_UP, VOTE_DOWN or
+// VOTE_NONE; the latter retracts any earlier vote.
+func CastVote(entryId int64, voter User, value int) (*Vote, error) {
+	v := &Vote{EntryId: entryId, UserId: voter.GetId()}
+	if err := v.SetValue(value); err != nil {
+		return nil, err
+	}
+
+	if err := v.persist(voter); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// Removes voter's vote on an entry, if any.
+func RetractVote(entryId int64, voter User) error {
+	_, err := CastVote(entryId, voter, VOTE_NONE)
+
+	return err
+}
+
+// Stores the vote to the database, replacing the user's earlier vote on the
+// entry. A vote that is neither up nor down retracts the earlier vote. The
+// voter is known only by v.UserId, so any Authorizer or Administrator behind it
+// is not consulted; use PersistAs where the User is at hand.
 func (v *Vote) Persist() error {
+	return v.persist(userId(v.UserId))
+}
+
+// Stores the vote as Persist does, cast by voter. Permission checks consult
+// voter itself, including its Authorizer or Administrator implementation.
+func (v *Vote) PersistAs(voter User) error {
+	return v.persist(voter)
+}
+
+func (v *Vote) persist(voter User) error {
+	//Validate
+	if v.Upvote && v.Downvote {
+		return ErrInvalidVote
+	}
+	v.UserId = voter.GetId()
+
 	//Wrap in a transaction
 	tx, err := Config.DB.Begin()
+	if err != nil {
+		return errors.New("Error: We had a database problem trying to create your vote.")
+	}
+
+	//Also ensures that the entry exists
+	e, err := entryState(tx, queries.EntryState, v.EntryId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = authorize(tx, voter, ACTION_VOTE, e); err != nil {
+		tx.Rollback()
+		return err
+	}
 
-	VoteCreateStmt, err := tx.Prepare(queries.VoteUpsert)
+	if Config.ForbidSelfVotes && e.AuthorId == v.UserId && v.Value() != VOTE_NONE {
+		tx.Rollback()
+		return ErrSelfVote
+	}
+
+	//Shadow-banned voters are told their vote was stored, but it is discarded
+	ban, err := activeBan(tx, v.EntryId, v.UserId)
 	if err != nil {
-		_ = tx.Rollback()
-		fmt.Println(err)
+		tx.Rollback()
+		return err
+	}
+	if ban != nil {
+		tx.Rollback()
+		if ban.Shadow {
+			return nil
+		}
+		return &BannedError{ForumId: ban.ForumId, UserId: v.UserId, Expires: ban.Expires}
+	}
+
+	//Votes may not be cast (or retracted) in archived threads
+	if err = checkThreadState(tx, v.EntryId, false); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = checkVoteRate(tx, v.UserId); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	//Lock the user's earlier vote, if any, so the counters move by the difference
+	old := &Vote{EntryId: v.EntryId, UserId: v.UserId}
+	err = tx.QueryRow(queries.VoteForUpdate, v.EntryId, v.UserId).Scan(&old.Upvote, &old.Downvote)
+	if err != nil && err != sql.ErrNoRows {
+		tx.Rollback()
 		return errors.New("Error: We had a database problem trying to create your vote.")
 	}
-	defer VoteCreateStmt.Close()
 
-	_, err = VoteCreateStmt.Exec(v.EntryId, v.UserId, v.Upvote, v.Downvote)
+	if v.Value() == VOTE_NONE {
+		_, err = tx.Exec(queries.VoteDelete, v.EntryId, v.UserId)
+		if err != nil {
+			tx.Rollback()
+			return errors.New("Error: Your vote could not be retracted.")
+		}
+	} else {
+		VoteCreateStmt, err := tx.Prepare(queries.VoteUpsert)
+		if err != nil {
+			_ = tx.Rollback()
+			return errors.New("Error: We had a database problem trying to create your vote.")
+		}
+		defer VoteCreateStmt.Close()
+
+		_, err = VoteCreateStmt.Exec(v.EntryId, v.UserId, v.Upvote, v.Downvote)
+		if err != nil {
+			tx.Rollback()
+			return errors.New("Error: Your vote could not be stored.")
+		}
+	}
+
+	if err = adjustVoteCounts(tx, old, v); err != nil {
+		tx.Rollback()
+		return errors.New("Error: Your vote could not be counted, so it was not stored.")
+	}
+
+	ev, err := recordVote(tx, old, v)
 	if err != nil {
 		tx.Rollback()
-		fmt.Println(err)
+		return errors.New("Error: Your vote could not be logged, so it was not stored.")
+	}
+
+	if err = tx.Commit(); err != nil {
 		return errors.New("Error: Your vote could not be stored.")
 	}
 
-	tx.Commit()
+	dispatch(ev)
 
 	return nil
 }
 
+//Moves the counters on the entry row, and its author's karma, from what the
+//old vote contributed to what the new vote contributes
+func adjustVoteCounts(tx *sql.Tx, old, v *Vote) error {
+	up, down := boolInt(v.Upvote)-boolInt(old.Upvote), boolInt(v.Downvote)-boolInt(old.Downvote)
+	if up == 0 && down == 0 {
+		return nil
+	}
+
+	if _, err := tx.Exec(queries.VoteCountsAdjust, v.EntryId, up, down); err != nil {
+		return err
+	}
+
+	return adjustKarma(tx, v.EntryId, up-down)
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// Recomputes the upvote and downvote counters kept on the entry rows from the
+// votes themselves, for the given entries or, if none are given, for every
+// entry. Returns the number of entries whose counters were wrong. Must be run
+// once, for every entry, after applying schema.sql to an existing database, as
+// the new counters start at 0. After that, counters only drift if votes are
+// changed without going through this package.
+func RepairVoteCounts(entryIds ...int64) (int64, error) {
+	var ids interface{}
+	if len(entryIds) > 0 {
+		ids = int64Array(entryIds)
+	}
+
+	res, err := Config.DB.Exec(queries.VoteCountsRepair, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
 //Retrieve one vote based on entry ID and user ID.
 func FindVote(entryId, userId int64) (*Vote, bool) {
 	v := new(Vote)
@@ -65,3 +256,56 @@ func FindVote(entryId, userId int64) (*Vote, bool) {
 
 	return v, true
 }
+
+//Retrieve one user's votes on many entries in one round-trip, keyed by entry
+//ID. Entries the user has not voted on are absent from the map.
+func FindVotes(userId int64, entryIds []int64) (map[int64]*Vote, error) {
+	votes := make(map[int64]*Vote, len(entryIds))
+	if len(entryIds) == 0 {
+		return votes, nil
+	}
+
+	rows, err := Config.DB.Query(queries.FindVotes, userId, int64Array(entryIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		v := new(Vote)
+		if err = rows.Scan(&v.EntryId, &v.UserId, &v.Upvote, &v.Downvote, &v.Created); err != nil {
+			return nil, err
+		}
+
+		votes[v.EntryId] = v
+	}
+
+	return votes, rows.Err()
+}
+
+//Sets UserVote on each of the given entries, and on all of their loaded
+//descendants, to the vote that user cast on it. Entries may be the roots of
+//trees (e.g. from DescendantEntries) or a flat list (e.g. from ForumPosts).
+func AttachVotes(user User, entries ...*Entry) error {
+	ids := make([]int64, 0, len(entries))
+	for _, root := range entries {
+		root.Walk(func(e *Entry) { ids = append(ids, e.Id) })
+	}
+
+	votes, err := FindVotes(user.GetId(), ids)
+	if err != nil {
+		return err
+	}
+
+	for _, root := range entries {
+		root.Walk(func(e *Entry) {
+			if v, ok := votes[e.Id]; ok {
+				e.UserVote = v
+			} else {
+				e.UserVote = &Vote{EntryId: e.Id, UserId: user.GetId()}
+			}
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package forum
+
+import (
+	"testing"
+)
+
+func TestVoteValue(t *testing.T) {
+	v := new(Vote)
+
+	for _, value := range []int{VOTE_UP, VOTE_DOWN, VOTE_NONE} {
+		if err := v.SetValue(value); err != nil {
+			t.Fatal(err)
+		}
+
+		if v.Value() != value {
+			t.Errorf("Got %d, expected %d", v.Value(), value)
+		}
+	}
+
+	if err := v.SetValue(2); err != ErrInvalidVote {
+		t.Errorf("Got %v, expected ErrInvalidVote", err)
+	}
+
+	v.Upvote, v.Downvote = true, true
+	if v.Value() != VOTE_NONE {
+		t.Errorf("A vote that is both up and down should count as neither, got %d", v.Value())
+	}
+}
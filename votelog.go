@@ -0,0 +1,131 @@
+/*
+Every change to a vote is appended to a vote event log, so that the history of
+a vote survives it being overwritten. The log is used to rate-limit voters and
+to look for rings of accounts that repeatedly vote the same way on the same
+entries at about the same time.
+
+For vote log methods and functions that access a database, see votelog_db.go
+*/
+package forum
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+type VoteEvent struct {
+	Id       int64     //The ID of this event
+	EntryId  int64     //The ID of the entry voted on
+	UserId   int64     //The ID of the voter
+	Value    int       //The vote after this event: VOTE_UP, VOTE_DOWN or VOTE_NONE
+	Previous int       //The vote before this event
+	Created  time.Time //Time at which the vote changed
+}
+
+//RateLimitError is returned when a user has voted more often than Config.VoteRateLimit allows
+type RateLimitError struct {
+	UserId int64         //The user who voted too often
+	Limit  int           //The number of votes allowed per Period
+	Period time.Duration //The period over which votes are counted
+}
+
+func (err *RateLimitError) Error() string {
+	return fmt.Sprintf("Error: You may only vote %d times every %s. Please try again later.", err.Limit, err.Period)
+}
+
+//A VoteRing is a group of accounts that voted the same way on the same entries
+//within a short time of one another, more often than chance would suggest
+type VoteRing struct {
+	UserIds  []int64 //The accounts in the ring, in ascending order
+	EntryIds []int64 //The entries that members of the ring voted on together, in ascending order
+}
+
+//Two accounts that cast the same vote on one entry close together in time
+type votePair struct {
+	A, B    int64 //The two accounts, A < B
+	EntryId int64 //The entry they both voted on
+}
+
+//Groups accounts into rings. Two accounts are linked when they paired up on at
+//least minShared different entries; a ring is every account reachable through
+//such links.
+func clusterVotePairs(pairs []votePair, minShared int) []*VoteRing {
+	type link struct{ a, b int64 }
+	shared := make(map[link]map[int64]bool)
+	for _, p := range pairs {
+		l := link{p.A, p.B}
+		if shared[l] == nil {
+			shared[l] = make(map[int64]bool)
+		}
+		shared[l][p.EntryId] = true
+	}
+
+	//Union-find over the accounts of sufficiently strong links
+	parent := make(map[int64]int64)
+	var find func(int64) int64
+	find = func(u int64) int64 {
+		if parent[u] == u {
+			return u
+		}
+		parent[u] = find(parent[u])
+		return parent[u]
+	}
+	for l, entries := range shared {
+		if len(entries) < minShared {
+			continue
+		}
+		for _, u := range []int64{l.a, l.b} {
+			if _, ok := parent[u]; !ok {
+				parent[u] = u
+			}
+		}
+		parent[find(l.a)] = find(l.b)
+	}
+
+	members := make(map[int64][]int64)
+	for u := range parent {
+		root := find(u)
+		members[root] = append(members[root], u)
+	}
+
+	entries := make(map[int64]map[int64]bool)
+	for l, e := range shared {
+		if len(e) < minShared {
+			continue
+		}
+		root := find(l.a)
+		if entries[root] == nil {
+			entries[root] = make(map[int64]bool)
+		}
+		for id := range e {
+			entries[root][id] = true
+		}
+	}
+
+	rings := make([]*VoteRing, 0, len(members))
+	for root, users := range members {
+		r := &VoteRing{UserIds: users}
+		for id := range entries[root] {
+			r.EntryIds = append(r.EntryIds, id)
+		}
+		sortInt64s(r.UserIds)
+		sortInt64s(r.EntryIds)
+
+		rings = append(rings, r)
+	}
+
+	//Largest rings first
+	sort.Slice(rings, func(i, j int) bool {
+		if len(rings[i].UserIds) != len(rings[j].UserIds) {
+			return len(rings[i].UserIds) > len(rings[j].UserIds)
+		}
+		return rings[i].UserIds[0] < rings[j].UserIds[0]
+	})
+
+	return rings
+}
+
+func sortInt64s(s []int64) {
+	sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+}
@@ -0,0 +1,146 @@
+/*
+Vote log methods and functions that access a database are placed here.
+*/
+package forum
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+//Appends a change of vote to the vote event log
+func logVote(tx *sql.Tx, old, v *Vote) error {
+	if old.Value() == v.Value() {
+		return nil
+	}
+
+	_, err := tx.Exec(queries.VoteEventCreate, v.EntryId, v.UserId, v.Value(), old.Value())
+
+	return err
+}
+
+//Logs a change of vote and records its event, if the vote's value changed.
+//Returns the event to dispatch once the transaction commits.
+func recordVote(tx *sql.Tx, old, v *Vote) (*Event, error) {
+	if old.Value() == v.Value() {
+		return nil, nil
+	}
+
+	if err := logVote(tx, old, v); err != nil {
+		return nil, err
+	}
+
+	kind := EVENT_VOTE_CAST
+	if v.Value() == VOTE_NONE {
+		kind = EVENT_VOTE_RETRACTED
+	}
+
+	return emit(tx, kind, v.EntryId, v.UserId, v.Value())
+}
+
+//Runs query, which adds votes to the entry with ID entryId or, if removed is
+//set, removes votes from it, returning the user_id, upvote and downvote of each.
+//Each change is logged and recorded as persist would record it. Returns the
+//events to dispatch once the transaction commits.
+func recordVotes(tx *sql.Tx, entryId int64, removed bool, query string, args ...interface{}) ([]*Event, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	votes := make([]*Vote, 0)
+	for rows.Next() {
+		v := &Vote{EntryId: entryId}
+		if err = rows.Scan(&v.UserId, &v.Upvote, &v.Downvote); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		votes = append(votes, v)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	events := make([]*Event, 0, len(votes))
+	for _, v := range votes {
+		old, cur := &Vote{EntryId: entryId, UserId: v.UserId}, v
+		if removed {
+			old, cur = v, old
+		}
+
+		ev, err := recordVote(tx, old, cur)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+
+	return events, nil
+}
+
+//Returns a *RateLimitError if the user has already used up their votes for the current period
+func checkVoteRate(tx *sql.Tx, userId int64) error {
+	if Config.VoteRateLimit <= 0 || Config.VoteRatePeriod <= 0 {
+		return nil
+	}
+
+	var recent int
+	if err := tx.QueryRow(queries.VoteEventsRecent, userId, Config.VoteRatePeriod.Seconds()).Scan(&recent); err != nil {
+		return errors.New("Error: We had a database problem trying to count your recent votes.")
+	}
+
+	if recent >= Config.VoteRateLimit {
+		return &RateLimitError{UserId: userId, Limit: Config.VoteRateLimit, Period: Config.VoteRatePeriod}
+	}
+
+	return nil
+}
+
+// Retrieves the history of one user's vote on an entry, oldest first.
+func VoteHistory(entryId, userId int64) ([]*VoteEvent, error) {
+	rows, err := Config.DB.Query(queries.VoteEvents, entryId, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]*VoteEvent, 0)
+	for rows.Next() {
+		ev := new(VoteEvent)
+		if err = rows.Scan(&ev.Id, &ev.EntryId, &ev.UserId, &ev.Value, &ev.Previous, &ev.Created); err != nil {
+			return nil, err
+		}
+
+		events = append(events, ev)
+	}
+
+	return events, rows.Err()
+}
+
+// Looks through the votes cast during the last lookback for rings of accounts
+// that cast the same vote on at least minShared of the same entries, each time
+// within window of one another. Rings are returned largest first.
+func DetectVoteRings(lookback, window time.Duration, minShared int) ([]*VoteRing, error) {
+	rows, err := Config.DB.Query(queries.VoteEventPairs, lookback.Seconds(), window.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pairs := make([]votePair, 0)
+	for rows.Next() {
+		var p votePair
+		if err = rows.Scan(&p.A, &p.B, &p.EntryId); err != nil {
+			return nil, err
+		}
+
+		pairs = append(pairs, p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return clusterVotePairs(pairs, minShared), nil
+}
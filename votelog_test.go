@@ -0,0 +1,32 @@
+package forum
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClusterVotePairs(t *testing.T) {
+	pairs := []votePair{
+		//1 and 2 vote together on three entries, as do 2 and 3
+		{1, 2, 10}, {1, 2, 11}, {1, 2, 12},
+		{2, 3, 11}, {2, 3, 12}, {2, 3, 13},
+		//4 and 5 only coincide once, which is not enough
+		{4, 5, 10},
+		//6 and 7 form a ring of their own
+		{6, 7, 20}, {6, 7, 21}, {6, 7, 21}, {6, 7, 22},
+	}
+
+	rings := clusterVotePairs(pairs, 3)
+
+	expected := []*VoteRing{
+		{UserIds: []int64{1, 2, 3}, EntryIds: []int64{10, 11, 12, 13}},
+		{UserIds: []int64{6, 7}, EntryIds: []int64{20, 21, 22}},
+	}
+
+	if !reflect.DeepEqual(rings, expected) {
+		for _, r := range rings {
+			t.Logf("%+v", *r)
+		}
+		t.Errorf("Got %d rings, expected %d", len(rings), len(expected))
+	}
+}